@@ -0,0 +1,86 @@
+package geneve
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MarshalAnnotated marshals a Header into binary form, and also returns a
+// slice of labels which describe the field each byte of the binary form
+// belongs to.  The labels slice is the same length as the returned bytes.
+//
+// MarshalAnnotated is intended for diagnostics and visualization tools; use
+// MarshalBinary when labels are not needed.
+func (h *Header) MarshalAnnotated() ([]byte, []string, error) {
+	b, err := h.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labels := make([]string, 0, len(b))
+	labels = appendLabels(labels, 1, "version+options length")
+	labels = appendLabels(labels, 1, "flags")
+	labels = appendLabels(labels, 2, "protocol type")
+	labels = appendLabels(labels, 3, "VNI")
+	labels = appendLabels(labels, 1, "reserved")
+
+	for i, o := range h.Options {
+		labels = appendLabels(labels, 2, fmt.Sprintf("option %d class", i))
+		labels = appendLabels(labels, 1, fmt.Sprintf("option %d critical+type", i))
+		labels = appendLabels(labels, 1, fmt.Sprintf("option %d length", i))
+		labels = appendLabels(labels, len(o.Data), fmt.Sprintf("option %d data", i))
+	}
+
+	return b, labels, nil
+}
+
+// appendLabels appends n copies of label to labels.
+func appendLabels(labels []string, n int, label string) []string {
+	for i := 0; i < n; i++ {
+		labels = append(labels, label)
+	}
+
+	return labels
+}
+
+// dumpPreviewLen is the maximum number of payload bytes rendered by Dump.
+const dumpPreviewLen = 16
+
+// Dump parses a Geneve datagram in b, consisting of a Header and its payload,
+// and renders a verbose, multi-line, human readable description of it.  The
+// description contains each field of the fixed header, including its reserved
+// fields, each Option, and the length of the payload along with a hexadecimal
+// preview of its first bytes.
+//
+// The format of the description is stable, so it is suitable for golden
+// files in tests, and for sharing packet captures in bug reports.
+func Dump(b []byte) (string, error) {
+	h, payload, err := Decapsulate(b)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "version: %d\n", h.Version)
+	fmt.Fprintf(&sb, "oam: %t\n", h.FlagOAM)
+	fmt.Fprintf(&sb, "critical: %t\n", h.FlagCritical)
+	fmt.Fprintf(&sb, "flags reserved: 0x%02x\n", h.FlagsReserved)
+	fmt.Fprintf(&sb, "protocol type: %s\n", h.ProtocolType)
+	fmt.Fprintf(&sb, "vni: 0x%06x\n", uint32(h.VNI))
+	fmt.Fprintf(&sb, "reserved: 0x%02x\n", h.Reserved)
+	fmt.Fprintf(&sb, "options: %d\n", len(h.Options))
+
+	for i, o := range h.Options {
+		fmt.Fprintf(&sb, "  option %d: class: 0x%04x, type: %d, critical: %t, data: [%x]\n",
+			i, o.OptionClass, o.Type, o.FlagCritical, o.Data)
+	}
+
+	preview, ellipsis := payload, ""
+	if len(preview) > dumpPreviewLen {
+		preview, ellipsis = preview[:dumpPreviewLen], "..."
+	}
+
+	fmt.Fprintf(&sb, "payload: %d bytes: [%x%s]\n", len(payload), preview, ellipsis)
+
+	return sb.String(), nil
+}
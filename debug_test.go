@@ -0,0 +1,95 @@
+package geneve
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderMarshalAnnotated(t *testing.T) {
+	if _, _, err := (&Header{VNI: MaxVNI + 1}).MarshalAnnotated(); err != errInvalidVNI {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidVNI, err)
+	}
+
+	h := &Header{
+		Options: []*Option{{
+			OptionClass: 0x0001,
+			Data:        []byte{0, 1, 2, 3},
+		}},
+	}
+
+	b, labels, err := h.MarshalAnnotated()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := len(b), len(labels); want != got {
+		t.Fatalf("unexpected number of labels:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	want := []string{
+		"version+options length",
+		"flags",
+		"protocol type", "protocol type",
+		"VNI", "VNI", "VNI",
+		"reserved",
+		"option 0 class", "option 0 class",
+		"option 0 critical+type",
+		"option 0 length",
+		"option 0 data", "option 0 data", "option 0 data", "option 0 data",
+	}
+	if got := labels; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected labels:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestDump(t *testing.T) {
+	if _, err := Dump(make([]byte, headerLen-1)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", io.ErrUnexpectedEOF, err)
+	}
+
+	b := []byte{
+		// Header
+		0x03,
+		0x55,
+		0x65, 0x58,
+		0xbb, 0xee, 0xff,
+		0x2a,
+		// Option
+		0x01, 0x02,
+		0x81,
+		0x01,
+		0xde, 0xad, 0xbe, 0xef,
+		// Option
+		0x00, 0x03,
+		0x02,
+		0x00,
+	}
+
+	// Payload is longer than the preview
+	for i := 0; i < 20; i++ {
+		b = append(b, byte(i))
+	}
+
+	s, err := Dump(b)
+	if err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+
+	const want = `version: 0
+oam: false
+critical: true
+flags reserved: 0x15
+protocol type: ethernet
+vni: 0xbbeeff
+reserved: 0x2a
+options: 2
+  option 0: class: 0x0102, type: 1, critical: true, data: [deadbeef]
+  option 1: class: 0x0003, type: 2, critical: false, data: []
+payload: 20 bytes: [000102030405060708090a0b0c0d0e0f...]
+`
+
+	if got := s; want != got {
+		t.Fatalf("unexpected dump:\n- want:\n%s\n-  got:\n%s", want, got)
+	}
+}
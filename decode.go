@@ -0,0 +1,140 @@
+package geneve
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	// errOptionDataTooLong indicates that an option's data length exceeds
+	// the maximum configured by DecodeOptions.
+	errOptionDataTooLong = errors.New("option data length exceeds configured maximum")
+
+	// errReservedNotZero indicates that reserved bits are set in a Header
+	// or Option when decoding in strict mode.
+	errReservedNotZero = errors.New("reserved bits must be zero")
+
+	// errTrailingBytes indicates that bytes remain after a Header and its
+	// payload when decoding with DecodeOptions.RequireExactLen.
+	errTrailingBytes = errors.New("unexpected trailing bytes after Header and payload")
+)
+
+// DecodeOptions specifies options which control how a Header is decoded
+// by Header.Decode.  The zero value of DecodeOptions decodes a Header
+// using the default behavior described for each field.
+type DecodeOptions struct {
+	// AllowFutureVersion specifies if a Header with a version other than
+	// Version may be decoded.  If true, the fixed header and options are
+	// decoded as if they were a Version header, and the observed version
+	// is stored in Header.Version.  If false, an error is returned when
+	// the version does not match Version.
+	AllowFutureVersion bool
+
+	// MaxOptionDataLen specifies the maximum length in bytes of the data
+	// carried by any single Option.  If an Option's data is longer, an error
+	// is returned before the Option is added to the Header.  The entire
+	// options area, which is at most MaxHeaderOptionsLen bytes, is copied
+	// before Options are checked, but no memory is allocated for the data of
+	// an individual Option.  If zero, the maximum permitted by the protocol
+	// applies.
+	MaxOptionDataLen int
+
+	// BestEffort specifies if non-critical Options which fail validation
+	// should be skipped, rather than causing an error.  A critical Option
+	// which fails validation always causes an error, because the packet
+	// must be dropped.  Options which cannot be parsed at all, such as an
+	// Option which extends past the end of the options area, always cause
+	// an error.
+	BestEffort bool
+
+	// Warn, if not nil, is invoked for each Option skipped due to
+	// BestEffort, along with the reason it was skipped.  The Option's
+	// Data points into the decoder's copy of the options area, or into the
+	// input byte slice if NoCopy is set, and must not be retained.
+	Warn func(o *Option, err error)
+
+	// Strict specifies if reserved bits must be zero.  If true, an error is
+	// returned if any reserved bits are set in the fixed header (the low 6
+	// bits of the second byte, and the final byte) or in an Option.
+	//
+	// This package assumes the Option layout of the Geneve draft: the third
+	// byte of an Option holds the critical bit followed by the 7 bit Type,
+	// and contains no reserved bits.  The fourth byte holds 3 reserved bits
+	// followed by the 5 bit length, so only those 3 bits are checked.
+	Strict bool
+
+	// LazyOptions specifies if parsing of Options should be deferred until
+	// Header.OptionsLazy is called, which is useful when Options are rarely
+	// needed.  The options area is still verified to be well-formed, but
+	// Header.Options is not populated during decoding.  Checks which apply
+	// to individual Options, such as MaxOptionDataLen, are performed when
+	// the Options are parsed by Header.OptionsLazy.
+	LazyOptions bool
+
+	// KeepRaw specifies if a copy of the binary form of a Header should be
+	// retained, so that it can be retrieved using Header.Raw even after the
+	// Header is modified.
+	KeepRaw bool
+
+	// NoCopy specifies if the Data of each Option should point into the
+	// input byte slice, rather than being copied.  This avoids an
+	// allocation, but the input must not be modified while the Header is
+	// in use.
+	NoCopy bool
+
+	// Intern, if not nil, is used to deduplicate the Data of each decoded
+	// Option, so that Options with identical Data share a single copy.  The
+	// Data of each Option then must not be modified; see Interner for
+	// details.  Intern is not applied to lazily parsed Options.
+	Intern *Interner
+
+	// RequireExactLen specifies if the input must be exactly long enough to
+	// contain the Header, its Options, and a payload of PayloadLen bytes.
+	// If the input is longer, an error is returned; if it is shorter,
+	// io.ErrUnexpectedEOF is returned.  This is useful for rejecting
+	// malformed or padded datagrams.
+	RequireExactLen bool
+
+	// PayloadLen specifies the expected length in bytes of the payload
+	// trailing the Header when RequireExactLen is set.  If zero, the input
+	// must contain only the Header and its Options.
+	PayloadLen int
+}
+
+// checkOption verifies that an Option is permitted by DecodeOptions.  raw
+// contains the binary form of the Option.
+func (opts *DecodeOptions) checkOption(o *Option, raw []byte) error {
+	if opts.Strict && raw[3]&0xe0 != 0 {
+		return errReservedNotZero
+	}
+
+	if opts.MaxOptionDataLen > 0 && len(o.Data) > opts.MaxOptionDataLen {
+		return errOptionDataTooLong
+	}
+
+	return nil
+}
+
+// checkLen verifies that an input of n bytes, containing a Header which ends
+// at offset off, is permitted by DecodeOptions.
+func (opts *DecodeOptions) checkLen(n, off int) error {
+	if !opts.RequireExactLen {
+		return nil
+	}
+
+	switch want := off + opts.PayloadLen; {
+	case n < want:
+		return io.ErrUnexpectedEOF
+	case n > want:
+		return errTrailingBytes
+	}
+
+	return nil
+}
+
+// Decode unmarshals a byte slice into a Header using the behavior specified
+// by opts.  If opts is nil, the zero value of DecodeOptions is used.
+func (h *Header) Decode(b []byte, opts *DecodeOptions) error {
+	_, err := h.decode(b, opts)
+	return err
+}
@@ -0,0 +1,790 @@
+package geneve
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderDecode(t *testing.T) {
+	// Version 1 header with a single option
+	b := []byte{
+		// Header
+		0x42,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x01,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+	}
+
+	tests := []struct {
+		desc string
+		opts *DecodeOptions
+		h    *Header
+		err  error
+	}{
+		{
+			desc: "nil options, future version",
+			err:  errInvalidVersion,
+		},
+		{
+			desc: "future version not allowed",
+			opts: &DecodeOptions{},
+			err:  errInvalidVersion,
+		},
+		{
+			desc: "future version allowed",
+			opts: &DecodeOptions{
+				AllowFutureVersion: true,
+			},
+			h: &Header{
+				Version:      1,
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          1,
+				Options: []*Option{{
+					OptionClass: 0x0001,
+					Type:        0x02,
+					Data:        []byte{0, 1, 2, 3},
+					DataLen:     4,
+				}},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		h := new(Header)
+		err := h.Decode(b, tt.opts)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.h, h; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderDecodeMaxOptionDataLen(t *testing.T) {
+	b := []byte{
+		// Header
+		0x05,
+		0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+		// Option
+		0x00, 0x02,
+		0x04,
+		0x02,
+		4, 5, 6, 7, 8, 9, 10, 11,
+	}
+
+	tests := []struct {
+		desc string
+		max  int
+		err  error
+	}{
+		{
+			desc: "protocol maximum",
+		},
+		{
+			desc: "equal to largest option",
+			max:  8,
+		},
+		{
+			desc: "smaller than largest option",
+			max:  4,
+			err:  errOptionDataTooLong,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		err := new(Header).Decode(b, &DecodeOptions{
+			MaxOptionDataLen: tt.max,
+		})
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderDecodeBestEffort(t *testing.T) {
+	// Header with an oversized non-critical option followed by a valid one
+	nonCritical := []byte{
+		// Header
+		0x05,
+		0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00,
+		0x00,
+		// Option
+		0x00, 0x02,
+		0x04,
+		0x02,
+		4, 5, 6, 7, 8, 9, 10, 11,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+	}
+
+	// Same Header, but with the oversized option marked critical
+	critical := make([]byte, len(nonCritical))
+	copy(critical, nonCritical)
+	critical[1] = 0x40
+	critical[10] = 0x84
+
+	tests := []struct {
+		desc  string
+		b     []byte
+		best  bool
+		h     *Header
+		warns int
+		err   error
+	}{
+		{
+			desc: "non-critical option, best effort disabled",
+			b:    nonCritical,
+			err:  errOptionDataTooLong,
+		},
+		{
+			desc: "critical option, best effort enabled",
+			b:    critical,
+			best: true,
+			err:  errOptionDataTooLong,
+		},
+		{
+			desc: "non-critical option, best effort enabled",
+			b:    nonCritical,
+			best: true,
+			h: &Header{
+				Options: []*Option{{
+					OptionClass: 0x0001,
+					Type:        0x02,
+					Data:        []byte{0, 1, 2, 3},
+					DataLen:     4,
+				}},
+			},
+			warns: 1,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var warns int
+		h := new(Header)
+		err := h.Decode(tt.b, &DecodeOptions{
+			MaxOptionDataLen: 4,
+			BestEffort:       tt.best,
+			Warn: func(o *Option, err error) {
+				if want, got := errOptionDataTooLong, err; want != got {
+					t.Fatalf("unexpected warning:\n- want: %v\n-  got: %v", want, got)
+				}
+
+				warns++
+			},
+		})
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.h, h; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.warns, warns; want != got {
+			t.Fatalf("unexpected number of warnings:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderDecodeStrict(t *testing.T) {
+	tests := []struct {
+		desc   string
+		b      []byte
+		strict bool
+		err    error
+	}{
+		{
+			desc: "reserved flags bits, lenient",
+			b: []byte{
+				0x00,
+				0x01,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+			},
+		},
+		{
+			desc: "reserved flags bits, strict",
+			b: []byte{
+				0x00,
+				0x01,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+			},
+			strict: true,
+			err:    errReservedNotZero,
+		},
+		{
+			desc: "reserved final byte, strict",
+			b: []byte{
+				0x00,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x01,
+			},
+			strict: true,
+			err:    errReservedNotZero,
+		},
+		{
+			desc: "reserved option bits, lenient",
+			b: []byte{
+				// Header
+				0x02,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x21,
+				0, 1, 2, 3,
+			},
+		},
+		{
+			desc: "reserved option bits, strict",
+			b: []byte{
+				// Header
+				0x02,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x21,
+				0, 1, 2, 3,
+			},
+			strict: true,
+			err:    errReservedNotZero,
+		},
+		{
+			desc: "critical and maximum type, strict OK",
+			b: []byte{
+				// Header
+				0x03,
+				0x40,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0xff,
+				0x00,
+				// Option
+				0x00, 0x02,
+				0x02,
+				0x01,
+				0, 1, 2, 3,
+			},
+			strict: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		err := new(Header).Decode(tt.b, &DecodeOptions{
+			Strict: tt.strict,
+		})
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderDecodeLazyOptions(t *testing.T) {
+	b := []byte{
+		// Header
+		0x02,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x01,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+	}
+
+	h := new(Header)
+	if err := h.Decode(b, &DecodeOptions{LazyOptions: true}); err != nil {
+		t.Fatalf("failed to decode Header: %v", err)
+	}
+
+	if h.Options != nil {
+		t.Fatalf("Options parsed eagerly: %v", h.Options)
+	}
+
+	// Input must not be aliased by the deferred options area
+	b[headerLen] = 0xff
+
+	want := Options{{
+		OptionClass: 0x0001,
+		Type:        0x02,
+		Data:        []byte{0, 1, 2, 3},
+		DataLen:     4,
+	}}
+
+	for i := 0; i < 2; i++ {
+		opts, err := h.OptionsLazy()
+		if err != nil {
+			t.Fatalf("failed to parse Options: %v", err)
+		}
+
+		if got := opts; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+
+	if got := h.Options; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected cached Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Malformed options areas are still rejected during decoding
+	b[headerLen+3] = 0x02
+	if err := new(Header).Decode(b, &DecodeOptions{LazyOptions: true}); err != errOptionsMisaligned {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errOptionsMisaligned, err)
+	}
+}
+
+func TestHeaderDecodeLazyOptionsChecks(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		opts *DecodeOptions
+		n    int
+		err  error
+	}{
+		{
+			desc: "strict reserved bits",
+			b: []byte{
+				// Header
+				0x02,
+				0x00,
+				0x65, 0x58,
+				0x00, 0x00, 0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x21,
+				0, 1, 2, 3,
+			},
+			opts: &DecodeOptions{LazyOptions: true, Strict: true},
+			err:  errReservedNotZero,
+		},
+		{
+			desc: "option data too long",
+			b: []byte{
+				// Header
+				0x02,
+				0x00,
+				0x65, 0x58,
+				0x00, 0x00, 0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x01,
+				0, 1, 2, 3,
+			},
+			opts: &DecodeOptions{LazyOptions: true, MaxOptionDataLen: 2},
+			err:  errOptionDataTooLong,
+		},
+		{
+			desc: "best effort skips option",
+			b: []byte{
+				// Header
+				0x03,
+				0x00,
+				0x65, 0x58,
+				0x00, 0x00, 0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x01,
+				0, 1, 2, 3,
+				// Option
+				0x00, 0x02,
+				0x03,
+				0x00,
+			},
+			opts: &DecodeOptions{LazyOptions: true, MaxOptionDataLen: 2, BestEffort: true},
+			n:    1,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		h := new(Header)
+		if err := h.Decode(tt.b, tt.opts); err != nil {
+			t.Fatalf("failed to decode Header: %v", err)
+		}
+
+		opts, err := h.OptionsLazy()
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.n, len(opts); want != got {
+			t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderDecodeKeepRaw(t *testing.T) {
+	b := []byte{
+		// Header
+		0x01,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x01,
+		0x01,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x00,
+		// Payload
+		1, 2, 3, 4,
+	}
+
+	h := new(Header)
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if raw := h.Raw(); raw != nil {
+		t.Fatalf("unexpected raw bytes without KeepRaw: %v", raw)
+	}
+
+	if err := h.Decode(b, &DecodeOptions{KeepRaw: true}); err != nil {
+		t.Fatalf("failed to decode Header: %v", err)
+	}
+
+	// Neither the input nor the Header may affect the raw bytes
+	want := make([]byte, 12)
+	copy(want, b)
+	b[0] = 0xff
+	h.VNI = 2
+
+	if got := h.Raw(); !bytes.Equal(want, got) {
+		t.Fatalf("unexpected raw bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderDecodeNoCopy(t *testing.T) {
+	b := []byte{
+		// Header
+		0x05,
+		0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+		// Option
+		0x00, 0x02,
+		0x04,
+		0x02,
+		4, 5, 6, 7, 8, 9, 10, 11,
+	}
+
+	h := new(Header)
+	if err := h.Decode(b, &DecodeOptions{NoCopy: true}); err != nil {
+		t.Fatalf("failed to decode Header: %v", err)
+	}
+
+	// Option data must point into the input
+	b[12] = 0xff
+	if want, got := []byte{0xff, 1, 2, 3}, h.Options[0].Data; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected Option data:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Appending to Option data must not overwrite the next Option
+	_ = append(h.Options[0].Data, 0xff)
+	if want, got := byte(0x00), b[16]; want != got {
+		t.Fatalf("next Option overwritten:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderDecodeIntern(t *testing.T) {
+	b := []byte{
+		// Header
+		0x04,
+		0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+		// Option
+		0x00, 0x02,
+		0x04,
+		0x01,
+		0, 1, 2, 3,
+	}
+
+	var in Interner
+	opts := &DecodeOptions{
+		NoCopy: true,
+		Intern: &in,
+	}
+
+	h1, h2 := new(Header), new(Header)
+	for _, h := range []*Header{h1, h2} {
+		if err := h.Decode(b, opts); err != nil {
+			t.Fatalf("failed to decode Header: %v", err)
+		}
+	}
+
+	// Identical Data within and across Headers must be shared
+	shared := &h1.Options[0].Data[0]
+	for _, o := range append(h1.Options, h2.Options...) {
+		if &o.Data[0] != shared {
+			t.Fatal("expected identical Option data to be shared")
+		}
+	}
+
+	if want, got := 1, in.Len(); want != got {
+		t.Fatalf("unexpected number of interned slices:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Interned data must never alias the input, even with NoCopy
+	b[12] = 0xff
+	if want, got := []byte{0, 1, 2, 3}, h1.Options[0].Data; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected Option data:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Empty data is not retained, and cannot alias its input
+	if got := in.Intern(make([]byte, 0, 4)); got != nil {
+		t.Fatalf("unexpected interned empty data: %v", got)
+	}
+}
+
+func TestHeaderDecodeRequireExactLen(t *testing.T) {
+	b := []byte{
+		// Header
+		0x01,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x01,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x00,
+		// Payload
+		0xff, 0xff, 0xff, 0xff,
+	}
+
+	tests := []struct {
+		desc string
+		b    []byte
+		opts *DecodeOptions
+		err  error
+	}{
+		{
+			desc: "disabled",
+			b:    b,
+			opts: &DecodeOptions{},
+		},
+		{
+			desc: "no payload OK",
+			b:    b[:12],
+			opts: &DecodeOptions{RequireExactLen: true},
+		},
+		{
+			desc: "unexpected payload",
+			b:    b,
+			opts: &DecodeOptions{RequireExactLen: true},
+			err:  errTrailingBytes,
+		},
+		{
+			desc: "payload OK",
+			b:    b,
+			opts: &DecodeOptions{RequireExactLen: true, PayloadLen: 4},
+		},
+		{
+			desc: "payload trailing bytes",
+			b:    append(b[:len(b):len(b)], 0x00),
+			opts: &DecodeOptions{RequireExactLen: true, PayloadLen: 4},
+			err:  errTrailingBytes,
+		},
+		{
+			desc: "payload too short",
+			b:    b[:14],
+			opts: &DecodeOptions{RequireExactLen: true, PayloadLen: 4},
+			err:  io.ErrUnexpectedEOF,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.err, new(Header).Decode(tt.b, tt.opts); want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderDecodeMaxOptionDataLenProtocolMaximum(t *testing.T) {
+	// A peer sends an Option with the maximum data length permitted by the
+	// protocol, which a memory-constrained receiver caps at 64 bytes
+	b := make([]byte, headerLen+optionHeaderLen+maxOptionLength*4)
+	b[0] = byte((optionHeaderLen + maxOptionLength*4) / 4)
+	b[headerLen+3] = maxOptionLength
+
+	if err := new(Header).UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header with protocol maximum: %v", err)
+	}
+
+	err := new(Header).Decode(b, &DecodeOptions{MaxOptionDataLen: 64})
+	if want, got := errOptionDataTooLong, err; want != got {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderDecodeKeepRawReuse(t *testing.T) {
+	b := []byte{
+		0x00,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x01,
+		0x00,
+	}
+
+	h := new(Header)
+	if err := h.Decode(b, &DecodeOptions{KeepRaw: true}); err != nil {
+		t.Fatalf("failed to decode Header: %v", err)
+	}
+	if h.Raw() == nil {
+		t.Fatal("expected raw form after decoding with KeepRaw")
+	}
+
+	// Reusing the Header without KeepRaw must discard the previous raw form
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+	if got := h.Raw(); got != nil {
+		t.Fatalf("unexpected raw form after reuse: %v", got)
+	}
+}
+
+func TestHeaderDecodeLazyOptionsReuse(t *testing.T) {
+	lazy := []byte{
+		// Header
+		0x02,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x01,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+	}
+
+	noOptions := []byte{
+		0x00,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x02,
+		0x00,
+	}
+
+	tests := []struct {
+		desc string
+		b    []byte
+		opts *DecodeOptions
+		n    int
+	}{
+		{
+			desc: "no options",
+			b:    noOptions,
+			opts: &DecodeOptions{LazyOptions: true},
+		},
+		{
+			desc: "eager",
+			b:    lazy,
+			opts: &DecodeOptions{},
+			n:    1,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		h := new(Header)
+		if err := h.Decode(lazy, &DecodeOptions{LazyOptions: true}); err != nil {
+			t.Fatalf("failed to decode lazy Header: %v", err)
+		}
+
+		// Reset Options as a caller reusing the Header would, then decode
+		// another packet, whose Options must be the only ones returned
+		h.Options = nil
+		if err := h.Decode(tt.b, tt.opts); err != nil {
+			t.Fatalf("failed to decode Header: %v", err)
+		}
+
+		opts, err := h.OptionsLazy()
+		if err != nil {
+			t.Fatalf("failed to parse Options: %v", err)
+		}
+
+		if want, got := tt.n, len(opts); want != got {
+			t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package geneve
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// The functions in this file read individual fields of a Geneve header
+// directly from its binary form, without allocating or decoding an entire
+// Header.  Each returns io.ErrUnexpectedEOF if b is too short to contain a
+// fixed header.
+
+// HeaderVersion returns the version field of a Geneve header in b.
+func HeaderVersion(b []byte) (uint8, error) {
+	if len(b) < headerLen {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	version, _ := unpackByte0(b[0])
+	return version, nil
+}
+
+// HeaderFlagOAM returns the OAM flag of a Geneve header in b.
+func HeaderFlagOAM(b []byte) (bool, error) {
+	if len(b) < headerLen {
+		return false, io.ErrUnexpectedEOF
+	}
+
+	oam, _, _ := unpackByte1(b[1])
+	return oam, nil
+}
+
+// HeaderFlagCritical returns the critical flag of a Geneve header in b.
+func HeaderFlagCritical(b []byte) (bool, error) {
+	if len(b) < headerLen {
+		return false, io.ErrUnexpectedEOF
+	}
+
+	_, critical, _ := unpackByte1(b[1])
+	return critical, nil
+}
+
+// HeaderProtocolType returns the protocol type of a Geneve header in b.
+func HeaderProtocolType(b []byte) (ProtocolType, error) {
+	if len(b) < headerLen {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return ProtocolType(binary.BigEndian.Uint16(b[2:4])), nil
+}
+
+// HeaderVNI returns the VNI of a Geneve header in b.
+func HeaderVNI(b []byte) (VNI, error) {
+	if len(b) < headerLen {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	// VNI is 24 bits
+	return VNI(binary.BigEndian.Uint32(b[4:8]) >> 8), nil
+}
+
+// HeaderByte0 returns the version and options length fields packed into the
+// first byte of a Geneve header in b.  The options length is returned in
+// bytes, rather than in the 4 byte units of the field.
+func HeaderByte0(b []byte) (version uint8, optionsLen int, err error) {
+	if len(b) < headerLen {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	// Options length is specified in 4 byte units
+	version, optLen4 := unpackByte0(b[0])
+	return version, int(optLen4) * 4, nil
+}
+
+// HeaderFlags returns the OAM and critical flags packed into the second byte
+// of a Geneve header in b.
+func HeaderFlags(b []byte) (oam, critical bool, err error) {
+	if len(b) < headerLen {
+		return false, false, io.ErrUnexpectedEOF
+	}
+
+	oam, critical, _ = unpackByte1(b[1])
+	return oam, critical, nil
+}
@@ -0,0 +1,116 @@
+package geneve
+
+import (
+	"io"
+	"testing"
+)
+
+func TestHeaderFields(t *testing.T) {
+	short := make([]byte, headerLen-1)
+	if _, err := HeaderVersion(short); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected HeaderVersion error: %v", err)
+	}
+	if _, err := HeaderFlagOAM(short); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected HeaderFlagOAM error: %v", err)
+	}
+	if _, err := HeaderFlagCritical(short); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected HeaderFlagCritical error: %v", err)
+	}
+	if _, err := HeaderProtocolType(short); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected HeaderProtocolType error: %v", err)
+	}
+	if _, err := HeaderVNI(short); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected HeaderVNI error: %v", err)
+	}
+	if _, _, err := HeaderByte0(short); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected HeaderByte0 error: %v", err)
+	}
+	if _, _, err := HeaderFlags(short); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected HeaderFlags error: %v", err)
+	}
+
+	tests := []struct {
+		desc string
+		h    *Header
+	}{
+		{
+			desc: "empty",
+			h:    &Header{},
+		},
+		{
+			desc: "OAM",
+			h: &Header{
+				FlagOAM: true,
+				VNI:     MaxVNI,
+			},
+		},
+		{
+			desc: "critical",
+			h: &Header{
+				FlagCritical: true,
+				ProtocolType: ProtocolTypeIPv6,
+				VNI:          0x00bbeeff,
+			},
+		},
+		{
+			desc: "future version",
+			h: &Header{
+				Version:      3,
+				FlagOAM:      true,
+				FlagCritical: true,
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          1,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		// Vary the options length field to verify it does not affect
+		// the version
+		b := make([]byte, headerLen)
+		tt.h.putFixed(b, byte(i))
+
+		version, _ := HeaderVersion(b)
+		if want, got := tt.h.Version, version; want != got {
+			t.Fatalf("unexpected version:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		oam, _ := HeaderFlagOAM(b)
+		if want, got := tt.h.FlagOAM, oam; want != got {
+			t.Fatalf("unexpected OAM flag:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		critical, _ := HeaderFlagCritical(b)
+		if want, got := tt.h.FlagCritical, critical; want != got {
+			t.Fatalf("unexpected critical flag:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		proto, _ := HeaderProtocolType(b)
+		if want, got := tt.h.ProtocolType, proto; want != got {
+			t.Fatalf("unexpected protocol type:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		vni, _ := HeaderVNI(b)
+		if want, got := tt.h.VNI, vni; want != got {
+			t.Fatalf("unexpected VNI:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		version, ol, _ := HeaderByte0(b)
+		if want, got := tt.h.Version, version; want != got {
+			t.Fatalf("unexpected byte 0 version:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := i*4, ol; want != got {
+			t.Fatalf("unexpected byte 0 options length:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		oam, critical, _ = HeaderFlags(b)
+		if want, got := tt.h.FlagOAM, oam; want != got {
+			t.Fatalf("unexpected flags OAM:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.h.FlagCritical, critical; want != got {
+			t.Fatalf("unexpected flags critical:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
@@ -3,6 +3,15 @@
 // IETF internet draft: https://tools.ietf.org/html/draft-ietf-nvo3-geneve-02.
 package geneve
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
 const (
 	// MaxVNI is the maximum possible value for a VNI: the maximum value
 	// of a 24-bit integer.
@@ -10,6 +19,21 @@ const (
 
 	// Version is the current version of the Geneve protocol.
 	Version = 0
+
+	// MaxHeaderOptionsLen is the maximum length in bytes of the options
+	// carried by a Header: the maximum value of the 6-bit options length
+	// field, in 4 byte units.
+	MaxHeaderOptionsLen = ((1 << 6) - 1) * 4
+
+	// MaxOptions is the maximum number of Options which can be carried
+	// by a Header: the number of minimal, 4 byte Options which fit in
+	// MaxHeaderOptionsLen bytes.
+	MaxOptions = MaxHeaderOptionsLen / 4
+
+	// Port is the IANA-assigned UDP destination port for Geneve, which may
+	// be used to identify Geneve traffic, such as when dispatching UDP
+	// payloads to a decoder.
+	Port = 6081
 )
 
 // A ProtocolType specifies the type of the protocol data unit appearing
@@ -20,8 +44,67 @@ const (
 	// ProtocolTypeEthernet indicates that an Ethernet frame is encapsulated
 	// by a Geneve header.
 	ProtocolTypeEthernet ProtocolType = 0x6558
+
+	// ProtocolTypeIPv4 indicates that an IPv4 packet is encapsulated
+	// by a Geneve header.
+	ProtocolTypeIPv4 ProtocolType = 0x0800
+
+	// ProtocolTypeARP indicates that an ARP packet is encapsulated
+	// by a Geneve header.
+	ProtocolTypeARP ProtocolType = 0x0806
+
+	// ProtocolTypeIPv6 indicates that an IPv6 packet is encapsulated
+	// by a Geneve header.
+	ProtocolTypeIPv6 ProtocolType = 0x86dd
+
+	// ProtocolTypeMPLS indicates that an MPLS unicast packet is encapsulated
+	// by a Geneve header.
+	ProtocolTypeMPLS ProtocolType = 0x8847
 )
 
+// protocolTypeNames maps ProtocolTypes to their human readable names.
+var protocolTypeNames = map[ProtocolType]string{
+	ProtocolTypeEthernet: "ethernet",
+	ProtocolTypeIPv4:     "ipv4",
+	ProtocolTypeARP:      "arp",
+	ProtocolTypeIPv6:     "ipv6",
+	ProtocolTypeMPLS:     "mpls",
+}
+
+// String returns the human readable name of a ProtocolType, or its
+// hexadecimal value if the ProtocolType is not known.
+func (p ProtocolType) String() string {
+	if s, ok := protocolTypeNames[p]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("0x%04x", uint16(p))
+}
+
+// ProtocolTypeFromName returns the ProtocolType with the human readable
+// name s, such as "ethernet" or "ipv6".  Names are case-insensitive.
+// Arbitrary ProtocolTypes may be specified in hexadecimal form, such as
+// "0x0800".  If s does not specify a ProtocolType, ok is false.
+func ProtocolTypeFromName(s string) (p ProtocolType, ok bool) {
+	s = strings.ToLower(s)
+	for p, name := range protocolTypeNames {
+		if s == name {
+			return p, true
+		}
+	}
+
+	if !strings.HasPrefix(s, "0x") {
+		return 0, false
+	}
+
+	v, err := strconv.ParseUint(s[2:], 16, 16)
+	if err != nil {
+		return 0, false
+	}
+
+	return ProtocolType(v), true
+}
+
 // A VNI is a 24-bit Virtual Network Identifier.  It is used to designate a
 // unique element of a virtual network.  Use its Valid method to determine if
 // a VNI contains a valid value.
@@ -31,3 +114,78 @@ type VNI uint32
 func (v VNI) Valid() bool {
 	return v <= MaxVNI
 }
+
+// SafeVNI converts u, such as a value from a control plane message, into a
+// VNI.  If u is too large to be a valid VNI, an error is returned, rather
+// than silently truncating u and potentially selecting the wrong virtual
+// network.
+func SafeVNI(u uint32) (VNI, error) {
+	v := VNI(u)
+	if !v.Valid() {
+		return 0, errInvalidVNI
+	}
+
+	return v, nil
+}
+
+// MaskVNI converts u into a VNI by discarding all but its low 24 bits.  Use
+// SafeVNI to reject out of range values instead.
+func MaskVNI(u uint32) VNI {
+	return VNI(u & MaxVNI)
+}
+
+// errVNIsUnaligned indicates that packed VNIs are not a multiple of 3 bytes
+// in length.
+var errVNIsUnaligned = errors.New("packed VNIs length must be multiple of 3")
+
+// PackVNIs packs vnis into a byte slice as consecutive 24-bit, big endian
+// values, such as for a control plane message which lists many VNIs.  If any
+// VNI is not valid, an error which specifies the index of the first invalid
+// VNI is returned.
+func PackVNIs(vnis []VNI) ([]byte, error) {
+	b := make([]byte, 0, len(vnis)*3)
+	for i, v := range vnis {
+		if !v.Valid() {
+			return nil, fmt.Errorf("VNI %d: %w", i, errInvalidVNI)
+		}
+
+		b = append(b, byte(v>>16), byte(v>>8), byte(v))
+	}
+
+	return b, nil
+}
+
+// UnpackVNIs unpacks VNIs packed by PackVNIs.  The length of b must be a
+// multiple of 3 bytes.
+func UnpackVNIs(b []byte) ([]VNI, error) {
+	if len(b)%3 != 0 {
+		return nil, errVNIsUnaligned
+	}
+
+	vnis := make([]VNI, 0, len(b)/3)
+	for i := 0; i < len(b); i += 3 {
+		vnis = append(vnis, VNI(b[i])<<16|VNI(b[i+1])<<8|VNI(b[i+2]))
+	}
+
+	return vnis, nil
+}
+
+// ethernetHeaderLen is the length of an Ethernet II frame header.
+const ethernetHeaderLen = 14
+
+// InnerEthernetDst returns the destination hardware address of an Ethernet
+// frame encapsulated in a Geneve payload, for use when a Header's ProtocolType
+// is ProtocolTypeEthernet.  If payload is too short to contain an Ethernet
+// frame header, io.ErrUnexpectedEOF is returned.
+//
+// The returned address is a copy, and does not point into payload.
+func InnerEthernetDst(payload []byte) (net.HardwareAddr, error) {
+	if len(payload) < ethernetHeaderLen {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	dst := make(net.HardwareAddr, 6)
+	copy(dst, payload[0:6])
+
+	return dst, nil
+}
@@ -0,0 +1,154 @@
+package geneve
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInnerEthernetDst(t *testing.T) {
+	if _, err := InnerEthernetDst(make([]byte, ethernetHeaderLen-1)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", io.ErrUnexpectedEOF, err)
+	}
+
+	payload := []byte{
+		// Destination
+		0xde, 0xad, 0xbe, 0xef, 0xde, 0xad,
+		// Source
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05,
+		// EtherType
+		0x08, 0x00,
+	}
+
+	dst, err := InnerEthernetDst(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := payload[0:6], []byte(dst); !bytes.Equal(want, got) {
+		t.Fatalf("unexpected destination:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestProtocolTypeFromName(t *testing.T) {
+	tests := []struct {
+		s  string
+		p  ProtocolType
+		ok bool
+	}{
+		{s: "ethernet", p: ProtocolTypeEthernet, ok: true},
+		{s: "IPv4", p: ProtocolTypeIPv4, ok: true},
+		{s: "IPV6", p: ProtocolTypeIPv6, ok: true},
+		{s: "arp", p: ProtocolTypeARP, ok: true},
+		{s: "mpls", p: ProtocolTypeMPLS, ok: true},
+		{s: "0x88cc", p: 0x88cc, ok: true},
+		{s: "0X0800", p: ProtocolTypeIPv4, ok: true},
+		{s: "0x10000"},
+		{s: "0xzz"},
+		{s: "0x"},
+		{s: "foo"},
+		{s: ""},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.s)
+
+		p, ok := ProtocolTypeFromName(tt.s)
+		if want, got := tt.ok, ok; want != got {
+			t.Fatalf("unexpected ok:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.p, p; want != got {
+			t.Fatalf("unexpected ProtocolType:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Names produced by String must be accepted by ProtocolTypeFromName
+		if !ok {
+			continue
+		}
+		if p2, _ := ProtocolTypeFromName(p.String()); p2 != p {
+			t.Fatalf("ProtocolType %q did not round trip: %v", p.String(), p2)
+		}
+	}
+}
+
+func TestSafeVNI(t *testing.T) {
+	tests := []struct {
+		desc string
+		u    uint32
+		vni  VNI
+		mask VNI
+		err  error
+	}{
+		{
+			desc: "zero",
+		},
+		{
+			desc: "maximum",
+			u:    MaxVNI,
+			vni:  MaxVNI,
+			mask: MaxVNI,
+		},
+		{
+			desc: "too large",
+			u:    0x01000002,
+			mask: 0x000002,
+			err:  errInvalidVNI,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		vni, err := SafeVNI(tt.u)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.vni, vni; want != got {
+			t.Fatalf("unexpected VNI:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.mask, MaskVNI(tt.u); want != got {
+			t.Fatalf("unexpected masked VNI:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestPackVNIs(t *testing.T) {
+	vnis := []VNI{0, 1, 0x00bbeeff, MaxVNI}
+
+	b, err := PackVNIs(vnis)
+	if err != nil {
+		t.Fatalf("failed to pack VNIs: %v", err)
+	}
+
+	want := []byte{
+		0x00, 0x00, 0x00,
+		0x00, 0x00, 0x01,
+		0xbb, 0xee, 0xff,
+		0xff, 0xff, 0xff,
+	}
+	if got := b; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected packed VNIs:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	got, err := UnpackVNIs(b)
+	if err != nil {
+		t.Fatalf("failed to unpack VNIs: %v", err)
+	}
+	if !reflect.DeepEqual(vnis, got) {
+		t.Fatalf("unexpected unpacked VNIs:\n- want: %v\n-  got: %v", vnis, got)
+	}
+
+	_, err = PackVNIs([]VNI{1, MaxVNI + 1, MaxVNI + 2})
+	if !errors.Is(err, errInvalidVNI) || !strings.Contains(err.Error(), "VNI 1:") {
+		t.Fatalf("unexpected pack error: %v", err)
+	}
+
+	if _, err := UnpackVNIs(b[:len(b)-1]); err != errVNIsUnaligned {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errVNIsUnaligned, err)
+	}
+}
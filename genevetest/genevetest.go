@@ -0,0 +1,43 @@
+// Package genevetest provides utilities for testing code which uses
+// package geneve.
+package genevetest
+
+import (
+	"fmt"
+
+	"github.com/mdlayher/geneve"
+)
+
+// RoundTrip unmarshals a Geneve header from b, marshals it again, and returns
+// an error describing the first byte which differs between the original and
+// remarshaled header.  Any payload trailing the header in b is ignored.
+//
+// Fields which package geneve does not preserve, such as reserved bits set in
+// options, will cause RoundTrip to return an error.
+func RoundTrip(b []byte) error {
+	h, payload, err := geneve.Decapsulate(b)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal header: %v", err)
+	}
+
+	want := b[:len(b)-len(payload)]
+
+	got, err := h.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to marshal header: %v", err)
+	}
+
+	if len(want) != len(got) {
+		return fmt.Errorf("header length mismatch: want %d bytes, got %d bytes",
+			len(want), len(got))
+	}
+
+	for i := range want {
+		if want[i] != got[i] {
+			return fmt.Errorf("header byte %d mismatch: want %#02x, got %#02x",
+				i, want[i], got[i])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package genevetest
+
+import "testing"
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		ok   bool
+	}{
+		{
+			desc: "input bytes too short for header",
+			b:    []byte{0x00},
+		},
+		{
+			desc: "unsupported version",
+			b: []byte{
+				0x40,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+			},
+		},
+		{
+			desc: "option reserved bits not preserved",
+			b: []byte{
+				// Header
+				0x01,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x20,
+			},
+		},
+		{
+			desc: "header reserved bits preserved",
+			b: []byte{
+				0x00,
+				0x3f,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x01,
+			},
+			ok: true,
+		},
+		{
+			desc: "OK",
+			b: []byte{
+				// Header
+				0x02,
+				0xc0,
+				0x65, 0x58,
+				0xbb, 0xee, 0xff,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x82,
+				0x01,
+				0, 1, 2, 3,
+				// Payload
+				1, 2, 3,
+			},
+			ok: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		err := RoundTrip(tt.b)
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected RoundTrip result:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
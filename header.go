@@ -1,9 +1,14 @@
 package geneve
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"slices"
+	"sort"
 )
 
 const (
@@ -17,6 +22,27 @@ var (
 
 	// errInvalidVNI indicates that a VNI contains an invalid value.
 	errInvalidVNI = errors.New("invalid VNI in Header")
+
+	// errOptionsUnaligned indicates that a Header's marshaled options are
+	// not a multiple of 4 bytes in length.
+	errOptionsUnaligned = errors.New("options length must be multiple of 4")
+
+	// errOptionsTooLong indicates that a Header's options are too long to
+	// be described by its options length field.
+	errOptionsTooLong = errors.New("options length exceeds maximum for Header")
+
+	// errImpossibleLength indicates that a length computed while marshaling
+	// or unmarshaling is negative or out of bounds, which indicates a bug
+	// in this package rather than invalid input.
+	errImpossibleLength = errors.New("impossible length computed")
+
+	// errInvalidFlagsReserved indicates that a Header's reserved flags do
+	// not fit in 6 bits.
+	errInvalidFlagsReserved = errors.New("invalid reserved flags in Header")
+
+	// errOptionsMisaligned indicates that a Header's options do not exactly
+	// consume its declared options area.
+	errOptionsMisaligned = errors.New("options do not match declared options length")
 )
 
 // A Header is a Geneve header, as described in the Geneve internet draft,
@@ -43,53 +69,321 @@ type Header struct {
 	VNI VNI
 
 	// Options contains zero or more Geneve options.
-	Options []*Option
+	Options Options
+
+	// FlagsReserved contains the 6 reserved bits which follow the flags.
+	// Reserved contains the final, reserved byte of the fixed header.  Both
+	// must be zero when sending a Header, but are preserved when decoding,
+	// so that a transit device can re-emit a Header without modifying
+	// reserved fields it does not understand.
+	FlagsReserved uint8
+	Reserved      uint8
+
+	// raw contains the binary form of a Header decoded with
+	// DecodeOptions.KeepRaw.
+	raw []byte
+
+	// rawOptions contains the unparsed options area of a Header decoded
+	// with DecodeOptions.LazyOptions, until it is parsed by OptionsLazy.
+	rawOptions []byte
+
+	// lazyOpts contains the DecodeOptions used to decode a Header with
+	// DecodeOptions.LazyOptions, so that OptionsLazy can apply them.
+	lazyOpts *DecodeOptions
 }
 
 // MarshalBinary allocates a byte slice and marshals a Header into binary form.
 func (h *Header) MarshalBinary() ([]byte, error) {
+	ol, f, err := h.marshalLen()
+	if err != nil {
+		return nil, err
+	}
+
+	// A Header with no Options is only the fixed header, so no work is
+	// done for Options in the common case
+	b := make([]byte, headerLen+ol)
+	h.put(b, f)
+
+	return b, nil
+}
+
+// MarshalBinaryAt marshals a Header into binary form in b, beginning at
+// offset off, and returns the offset immediately following the Header.
+// This is useful when outer headers have already been built in b.  If b
+// is too short to contain the Header at off, io.ErrShortBuffer is returned.
+func (h *Header) MarshalBinaryAt(b []byte, off int) (int, error) {
+	ol, f, err := h.marshalLen()
+	if err != nil {
+		return 0, err
+	}
+
+	n := headerLen + ol
+	if off < 0 || len(b)-off < n {
+		return 0, io.ErrShortBuffer
+	}
+
+	h.put(b[off:off+n], f)
+	return off + n, nil
+}
+
+// MarshalBinaryUnchecked allocates a byte slice and marshals a Header into
+// binary form, as MarshalBinary does, but without verifying the Header's
+// Version, VNI, and FlagsReserved.  This avoids redundant work in a trusted
+// datapath where every Header has already been verified, such as by a
+// control plane.
+//
+// If the Version or VNI of the Header are not valid, the bytes produced for
+// them are undefined.  If FlagsReserved does not fit in 6 bits, its high bits
+// are discarded.  Options are always verified, because their lengths
+// determine the layout of the Header.
+func (h *Header) MarshalBinaryUnchecked() ([]byte, error) {
+	ol, err := h.optionsLen()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := optionsLenField(ol)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, headerLen+ol)
+	h.put(b, f)
+
+	return b, nil
+}
+
+// MarshalFixedHeader marshals a Geneve header with no Options into a fixed
+// size array, which avoids allocation entirely.  This is useful for
+// encapsulating packets which never carry Options.  If version or vni are
+// not valid, an error is returned.
+func MarshalFixedHeader(version uint8, oam, critical bool, proto ProtocolType, vni VNI) ([8]byte, error) {
+	var b [8]byte
+
+	h := Header{
+		Version:      version,
+		FlagOAM:      oam,
+		FlagCritical: critical,
+		ProtocolType: proto,
+		VNI:          vni,
+	}
+
+	if h.Version != Version {
+		return b, errInvalidVersion
+	}
+	if !h.VNI.Valid() {
+		return b, errInvalidVNI
+	}
+
+	h.putFixed(b[:], 0)
+	return b, nil
+}
+
+// MarshalHeaderStream marshals a Geneve header into binary form, with Options
+// produced by emit rather than stored in a Header.  emit must call add once
+// for each Option, in order; each Option is verified and marshaled as it is
+// added, so it is not retained.  This is useful for producers which compute
+// Options on the fly.
+//
+// If add returns an error because an Option is invalid, or because the
+// Options are too long for a Header, emit should return that error.  Any
+// error returned by emit is returned by MarshalHeaderStream.
+func MarshalHeaderStream(version uint8, oam, critical bool, proto ProtocolType, vni VNI, emit func(add func(o *Option) error) error) ([]byte, error) {
+	h := Header{
+		Version:      version,
+		FlagOAM:      oam,
+		FlagCritical: critical,
+		ProtocolType: proto,
+		VNI:          vni,
+	}
+
+	if _, _, err := h.marshalLen(); err != nil {
+		return nil, err
+	}
+
+	// Allocate enough space for the largest possible options area, so the
+	// buffer never needs to grow
+	b := make([]byte, headerLen, headerLen+MaxHeaderOptionsLen)
+	add := func(o *Option) error {
+		if err := o.validate(); err != nil {
+			return err
+		}
+
+		n := optionHeaderLen + len(o.Data)
+		if len(b)-headerLen+n > MaxHeaderOptionsLen {
+			return errOptionsTooLong
+		}
+
+		b = b[:len(b)+n]
+		o.put(b[len(b)-n:])
+		return nil
+	}
+
+	if err := emit(add); err != nil {
+		return nil, err
+	}
+
+	f, err := optionsLenField(len(b) - headerLen)
+	if err != nil {
+		return nil, err
+	}
+
+	h.putFixed(b, f)
+	return b, nil
+}
+
+// marshalLen verifies that a Header can be marshaled into binary form, and
+// returns the length of its marshaled Options in bytes, and the value of its
+// options length field.
+func (h *Header) marshalLen() (int, byte, error) {
 	// Must use correct Geneve version
 	if h.Version != Version {
-		return nil, errInvalidVersion
+		return 0, 0, errInvalidVersion
 	}
 
 	// VNI must be valid
 	if !h.VNI.Valid() {
-		return nil, errInvalidVNI
+		return 0, 0, errInvalidVNI
 	}
 
-	// Marshal all Options into binary to be appended to Header bytes
-	var obs []byte
-	for _, o := range h.Options {
-		ob, err := o.MarshalBinary()
-		if err != nil {
-			return nil, err
-		}
+	// Reserved flags must fit in the remainder of the flags byte
+	if h.FlagsReserved > 0x3f {
+		return 0, 0, errInvalidFlagsReserved
+	}
 
-		obs = append(obs, ob...)
+	ol, err := h.optionsLen()
+	if err != nil {
+		return 0, 0, err
 	}
 
-	b := make([]byte, headerLen)
-	b[0] |= (h.Version << 6)
-	b[0] |= byte(len(obs) / 4)
+	f, err := optionsLenField(ol)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ol, f, nil
+}
+
+// put marshals a Header into b, using f as the value of the options length
+// field.  The Header must be verified using marshalLen, and b must be exactly
+// long enough to contain the Header and its Options.
+func (h *Header) put(b []byte, f byte) {
+	h.putFixed(b, f)
+	h.putOptions(b[headerLen:])
+}
 
-	if h.FlagOAM {
-		b[1] |= (1 << 7)
+// putFixed marshals the fixed portion of a Header into b, using f as the
+// value of the options length field.  b must be at least headerLen bytes.
+func (h *Header) putFixed(b []byte, f byte) {
+	b[0] = packByte0(h.Version, f)
+	b[1] = packByte1(h.FlagOAM, h.FlagCritical, h.FlagsReserved)
+
+	binary.BigEndian.PutUint16(b[2:4], uint16(h.ProtocolType))
+
+	// VNI is 24 bits and is followed by the final reserved byte
+	binary.BigEndian.PutUint32(b[4:8], uint32(h.VNI)<<8|uint32(h.Reserved))
+}
+
+// packByte0 packs the 2 bit version and the 6 bit options length, in 4 byte
+// units, into the first byte of a Geneve header.
+func packByte0(version, optLen4 uint8) byte {
+	return (version << 6) | (optLen4 & 0x3f)
+}
+
+// unpackByte0 unpacks the version and options length, in 4 byte units, from
+// the first byte of a Geneve header.
+func unpackByte0(b byte) (version, optLen4 uint8) {
+	return b >> 6, b & 0x3f
+}
+
+// packByte1 packs the OAM and critical flags and the 6 reserved bits into the
+// second byte of a Geneve header.
+func packByte1(oam, critical bool, reserved uint8) byte {
+	b := reserved & 0x3f
+	if oam {
+		b |= (1 << 7)
 	}
-	if h.FlagCritical {
-		b[1] |= (1 << 6)
+	if critical {
+		b |= (1 << 6)
 	}
 
-	binary.BigEndian.PutUint16(b[2:4], uint16(h.ProtocolType))
+	return b
+}
 
-	// VNI is 24 bits and must leave last 8 bits of Header reserved
-	binary.BigEndian.PutUint32(b[4:8], uint32(h.VNI)<<8)
+// unpackByte1 unpacks the OAM and critical flags and the 6 reserved bits from
+// the second byte of a Geneve header.
+func unpackByte1(b byte) (oam, critical bool, reserved uint8) {
+	return (b >> 7) == 1, ((b & 0x40) >> 6) == 1, b & 0x3f
+}
+
+// putOptions marshals the Options of a Header into b.  The Options must be
+// verified using optionsLen, and b must be long enough to contain them.
+func (h *Header) putOptions(b []byte) {
+	var i int
+	for _, o := range h.Options {
+		i += o.put(b[i:])
+	}
+}
+
+// MarshalOptions allocates a byte slice and marshals only the Options of a
+// Header into binary form, without the fixed header.  If a Header contains
+// no Options, an empty byte slice is returned.
+func (h *Header) MarshalOptions() ([]byte, error) {
+	ol, err := h.optionsLen()
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, ol)
+	h.putOptions(b)
 
-	b = append(b, obs...)
 	return b, nil
 }
 
+// optionsLen verifies that the Options of a Header can be marshaled into
+// binary form, and returns the length of the marshaled Options in bytes.
+func (h *Header) optionsLen() (int, error) {
+	var n int
+	for _, o := range h.Options {
+		if err := o.validate(); err != nil {
+			return 0, err
+		}
+
+		n += optionHeaderLen + len(o.Data)
+	}
+
+	// Options must fit in the Header's options length field
+	if n > MaxHeaderOptionsLen {
+		return 0, errOptionsTooLong
+	}
+
+	return n, nil
+}
+
+// optionsLenField computes the value of a Header's options length field
+// from the length of its marshaled options, in bytes.
+func optionsLenField(n int) (byte, error) {
+	// Each Option is marshaled as a multiple of 4 bytes, but the options
+	// length field is encoded in 4 byte units, so any remainder would be
+	// silently dropped and desynchronize the field from the options
+	if n%4 != 0 {
+		return 0, errOptionsUnaligned
+	}
+
+	// The 6-bit field cannot describe a negative or oversized length
+	if n < 0 || n > MaxHeaderOptionsLen {
+		return 0, errImpossibleLength
+	}
+
+	return byte(n / 4), nil
+}
+
 // UnmarshalBinary unmarshals a byte slice into a Header.
+//
+// UnmarshalBinary does not verify the version of the Header; the observed
+// version is stored in h.Version.  Use Decode to control this behavior.
+//
+// Each Option appended to h.Options is guaranteed to be non-nil.
 func (h *Header) UnmarshalBinary(b []byte) error {
 	_, err := h.unmarshalBinaryOffset(b)
 	return err
@@ -99,46 +393,794 @@ func (h *Header) UnmarshalBinary(b []byte) error {
 // the offset of the payload trailing the Header, for consumption within
 // this package.
 func (h *Header) unmarshalBinaryOffset(b []byte) (int, error) {
+	return h.decode(b, &DecodeOptions{
+		AllowFutureVersion: true,
+	})
+}
+
+// decode unmarshals a byte slice into a Header using the specified
+// DecodeOptions, and returns the offset of the payload trailing the Header.
+func (h *Header) decode(b []byte, opts *DecodeOptions) (int, error) {
+	if opts == nil {
+		opts = &DecodeOptions{}
+	}
+
+	// Discard the raw form of any previously decoded Header, so that it
+	// cannot be mistaken for the raw form of this one
+	h.raw = nil
+
+	// Likewise, discard any unparsed options area, so that OptionsLazy
+	// cannot parse the Options of a previously decoded Header
+	h.rawOptions = nil
+	h.lazyOpts = nil
+
+	ol, err := h.unmarshalFixed(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if h.Version != Version && !opts.AllowFutureVersion {
+		return 0, errInvalidVersion
+	}
+
+	if err := opts.checkLen(len(b), headerLen+ol); err != nil {
+		return 0, err
+	}
+
+	// Reserved bits in the flags byte and the final byte must be zero
+	if opts.Strict && (h.FlagsReserved != 0 || h.Reserved != 0) {
+		return 0, errReservedNotZero
+	}
+
+	if opts.KeepRaw {
+		h.raw = make([]byte, headerLen+ol)
+		copy(h.raw, b)
+	}
+
+	// Check for no options present
+	if ol == 0 {
+		// Payload offset begins after header
+		return headerLen, nil
+	}
+
+	// Verify the options area and count its Options before parsing, so
+	// that all of the Options can be allocated at once
+	ob := b[headerLen : headerLen+ol]
+	n, err := checkOptions(ob)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.LazyOptions {
+		// The payload offset can now be trusted, but defer parsing
+		// until OptionsLazy is called
+		h.rawOptions = make([]byte, len(ob))
+		copy(h.rawOptions, ob)
+
+		lo := *opts
+		h.lazyOpts = &lo
+
+		return headerLen + ol, nil
+	}
+
+	// Copy the entire options area at once, unless the caller permits Option
+	// data to point into the input
+	if !opts.NoCopy {
+		obc := make([]byte, len(ob))
+		copy(obc, ob)
+		ob = obc
+	}
+
+	hopts, err := parseOptionsArea(h.Options, ob, n, opts)
+	if err != nil {
+		return 0, err
+	}
+	h.Options = hopts
+
+	// Payload offset occurs after header and all options
+	return headerLen + ol, nil
+}
+
+// parseOptionsArea parses the n Options in the options area ob, applying the
+// per-Option checks of opts, and appends them to dst.  The Data of each
+// Option points into ob.
+func parseOptionsArea(dst Options, ob []byte, n int, opts *DecodeOptions) (Options, error) {
+	// Allocate all Options at once, and grow the Options slice only once
+	slab := make([]Option, n)
+	if cap(dst)-len(dst) < n {
+		grown := make(Options, len(dst), len(dst)+n)
+		copy(grown, dst)
+		dst = grown
+	}
+
+	// parseOptions reuses a single Option, so each Option is copied into
+	// the slab, with its Data pointing into the options area
+	var i int
+	err := parseOptions(ob, func(o *Option) error {
+		// Track the raw bytes of each Option so they can be checked
+		end := i + optionHeaderLen + len(o.Data)
+		if end > len(ob) {
+			return errImpossibleLength
+		}
+
+		raw := ob[i:end]
+		i = end
+
+		if err := opts.checkOption(o, raw); err != nil {
+			// Only non-critical Options may be skipped
+			if !opts.BestEffort || o.FlagCritical {
+				return err
+			}
+
+			if opts.Warn != nil {
+				opts.Warn(o, err)
+			}
+
+			return nil
+		}
+
+		so := &slab[0]
+		slab = slab[1:]
+
+		*so = *o
+
+		// Cap Data so that appending to it cannot overwrite the
+		// next Option
+		so.Data = o.Data[:len(o.Data):len(o.Data)]
+		if opts.Intern != nil {
+			so.Data = opts.Intern.Intern(so.Data)
+		}
+
+		dst = append(dst, so)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The parsed Options must exactly consume the declared options area
+	if i != len(ob) {
+		return nil, errOptionsMisaligned
+	}
+
+	return dst, nil
+}
+
+// unmarshalFixed unmarshals the fixed portion of a Header from a byte slice,
+// and returns the length of the options area which follows it.  The byte
+// slice is verified to be long enough to contain the entire options area.
+func (h *Header) unmarshalFixed(b []byte) (int, error) {
 	// Must contain enough data to produce a Header
 	if len(b) < headerLen {
 		return 0, io.ErrUnexpectedEOF
 	}
 
-	h.Version = b[0] >> 6
+	version, optLen4 := unpackByte0(b[0])
+	h.Version = version
 
-	// Low 6 bits, multiplied by 4, produce options length
-	ol := int(b[0]&0x3f) * 4
+	// Options length is specified in 4 byte units
+	ol := int(optLen4) * 4
 
 	if len(b) < headerLen+ol {
 		return 0, io.ErrUnexpectedEOF
 	}
 
-	h.FlagOAM = (b[1] >> 7) == 1
-	h.FlagCritical = ((b[1] & 0x40) >> 6) == 1
+	h.FlagOAM, h.FlagCritical, h.FlagsReserved = unpackByte1(b[1])
 
 	h.ProtocolType = ProtocolType(binary.BigEndian.Uint16(b[2:4]))
 
 	// VNI is 24 bits
 	h.VNI = VNI(binary.BigEndian.Uint32(b[4:8]) >> 8)
+	h.Reserved = b[7]
 
-	// Check for no options present
-	if ol == 0 {
-		// Payload offset begins after header
-		return headerLen, nil
+	return ol, nil
+}
+
+// WalkOptions parses the fixed portion of a Geneve header from a byte slice,
+// and then invokes fn for each Option present in the header, without building
+// a slice of Options.  The offset of the payload trailing the header is
+// returned.
+//
+// The Option passed to fn is reused between calls, and its Data field points
+// into b.  fn must not retain the Option or its Data after it returns; use
+// Header.UnmarshalBinary if the Options must be retained.  If fn returns an
+// error, iteration stops and the error is returned.
+func WalkOptions(b []byte, fn func(o *Option) error) (int, error) {
+	var h Header
+	ol, err := h.unmarshalFixed(b)
+	if err != nil {
+		return 0, err
 	}
 
-	i := headerLen
-	for i <= ol {
-		o := new(Option)
-		if err := o.UnmarshalBinary(b[i:]); err != nil {
-			return 0, err
+	if err := parseOptions(b[headerLen:headerLen+ol], fn); err != nil {
+		return 0, err
+	}
+
+	return headerLen + ol, nil
+}
+
+// OptionClassCounts returns the number of Options present in a Header for
+// each OptionClass.  If a Header contains no Options, an empty map is returned.
+func (h *Header) OptionClassCounts() map[uint16]int {
+	counts := make(map[uint16]int)
+	for _, o := range h.Options {
+		counts[o.OptionClass]++
+	}
+
+	return counts
+}
+
+// OptionClasses returns the distinct OptionClasses of the Options present in
+// a Header, in the order in which each OptionClass first appears.  If a Header
+// contains no Options, nil is returned.
+func (h *Header) OptionClasses() []uint16 {
+	var classes []uint16
+	for _, o := range h.Options {
+		if !slices.Contains(classes, o.OptionClass) {
+			classes = append(classes, o.OptionClass)
+		}
+	}
+
+	return classes
+}
+
+// NewOAMPacket creates a minimal Header suitable for an OAM (Operations,
+// Administration, and Management) packet, such as a keepalive sent between
+// tunnel endpoints.  The Header has FlagOAM set, the specified VNI, a
+// ProtocolType of 0, and no Options.  If vni is not valid, an error is
+// returned.
+func NewOAMPacket(vni VNI) (*Header, error) {
+	if !vni.Valid() {
+		return nil, errInvalidVNI
+	}
+
+	return &Header{
+		Version: Version,
+		FlagOAM: true,
+		VNI:     vni,
+	}, nil
+}
+
+// FlowHash computes a hash of a Header's VNI and ProtocolType, suitable for
+// selecting a path when load balancing tunneled traffic.  Options and flags
+// do not affect the hash.
+//
+// The hash is the 64-bit FNV-1a hash of the 24-bit VNI followed by the 16-bit
+// ProtocolType, both in big endian byte order.  This definition is stable and
+// will not change in future versions of this package, so the hash may be
+// persisted.
+func (h *Header) FlowHash() uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+
+	hash := uint64(offset64)
+	for _, c := range h.Key() {
+		hash ^= uint64(c)
+		hash *= prime64
+	}
+
+	return hash
+}
+
+// Key returns a comparable key for a Header, suitable for use as a map key
+// in a flow table.  The key contains only the 24-bit VNI followed by the
+// 16-bit ProtocolType, both in big endian byte order; flags and Options do
+// not affect the key.  This definition is stable and will not change in
+// future versions of this package, so the key may be persisted.
+func (h *Header) Key() [5]byte {
+	return [5]byte{
+		byte(h.VNI >> 16),
+		byte(h.VNI >> 8),
+		byte(h.VNI),
+		byte(h.ProtocolType >> 8),
+		byte(h.ProtocolType),
+	}
+}
+
+// Reset zeroes all fields of a Header so that it may be reused, such as
+// with a sync.Pool.  The Options slice is truncated to length zero, but its
+// capacity is retained.
+func (h *Header) Reset() {
+	// Clear Option pointers so the Options can be garbage collected
+	for i := range h.Options {
+		h.Options[i] = nil
+	}
+
+	*h = Header{
+		Options: h.Options[:0],
+	}
+}
+
+// ReadHeader reads a Header, including its Options, from r.  Exactly the
+// bytes of the Header are consumed from r, so any payload trailing the Header
+// remains in r for use by another decoder.
+//
+// If r contains no data, io.EOF is returned.  If r ends before an entire
+// Header can be read, io.ErrUnexpectedEOF is returned.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	// Peek at the fixed header to determine the length of the options area
+	fb, err := r.Peek(headerLen)
+	if err != nil {
+		if err == io.EOF && len(fb) > 0 {
+			return nil, io.ErrUnexpectedEOF
 		}
 
-		// Each option is offset by length of its header and data
-		h.Options = append(h.Options, o)
-		i += optionHeaderLen + len(o.Data)
+		return nil, err
 	}
 
-	// Payload offset occurs after header and all options
-	return i, nil
+	ol, err := DeclaredOptionsLen(fb)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, headerLen+ol)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	h := new(Header)
+	if err := h.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// SetProtocolFromEtherType sets a Header's ProtocolType from an EtherType
+// value, such as one obtained from another decoder.  Geneve protocol types
+// share the EtherType number space, so no conversion is performed.
+func (h *Header) SetProtocolFromEtherType(e uint16) {
+	h.ProtocolType = ProtocolType(e)
+}
+
+// SetProtocolEthernet sets a Header's ProtocolType to ProtocolTypeEthernet,
+// indicating that an Ethernet frame is encapsulated.
+func (h *Header) SetProtocolEthernet() {
+	h.ProtocolType = ProtocolTypeEthernet
+}
+
+// IsMinimal reports whether a Header carries no Options, and is therefore
+// encoded in its minimal, 8 byte form.
+func (h *Header) IsMinimal() bool {
+	return len(h.Options) == 0
+}
+
+// HasOptions reports whether a Header carries one or more Options.  It is the
+// inverse of IsMinimal.
+func (h *Header) HasOptions() bool {
+	return len(h.Options) > 0
+}
+
+// NewHeaderWithOptions creates a Header with the current Version, the
+// specified VNI and ProtocolType, and zero or more Options.  FlagCritical is
+// set if any of the Options is critical.  The Header is verified to be valid
+// for marshaling, and the first error encountered is returned if it is not.
+func NewHeaderWithOptions(vni VNI, proto ProtocolType, opts ...*Option) (*Header, error) {
+	h := &Header{
+		Version:      Version,
+		ProtocolType: proto,
+		VNI:          vni,
+		Options:      opts,
+	}
+	h.FlagCritical = h.HasCriticalOption()
+
+	if _, _, err := h.marshalLen(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// DeclaredOptionsLen returns the length in bytes of the options area declared
+// by the options length field of a Geneve header in b.  Only the fixed header
+// is inspected: the options area is not parsed, and need not be present in b.
+// This is useful for comparing the declared length with the Options actually
+// present.  If b is too short to contain a fixed header, io.ErrUnexpectedEOF
+// is returned.
+func DeclaredOptionsLen(b []byte) (int, error) {
+	if len(b) < headerLen {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	// Options length is specified in 4 byte units
+	_, optLen4 := unpackByte0(b[0])
+	return int(optLen4) * 4, nil
+}
+
+// AppendOptions appends the Options of a Header to dst, and returns the
+// resulting slice.  The Option pointers are shared with the Header rather
+// than cloned, so modifying an appended Option also modifies the Header's.
+func (h *Header) AppendOptions(dst []*Option) []*Option {
+	return append(dst, h.Options...)
+}
+
+// HasCriticalOption reports whether a Header contains one or more Options
+// with the critical bit set.
+func (h *Header) HasCriticalOption() bool {
+	for _, o := range h.Options {
+		if o.FlagCritical {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FlagsConsistent reports whether a Header's FlagCritical matches its
+// Options: FlagCritical must be set if and only if one or more Options are
+// critical.  An inconsistent Header may indicate a middlebox which removed
+// Options without updating the flag.
+func (h *Header) FlagsConsistent() bool {
+	return h.FlagCritical == h.HasCriticalOption()
+}
+
+// PartitionCritical partitions the critical Options of a Header into those
+// with an OptionClass present in known, and those without.  Non-critical
+// Options are not returned.
+//
+// A decapsulating endpoint must drop a packet with any unsupported critical
+// Options, so if unsupported is not empty, the packet must be dropped.
+// Otherwise, supported contains the critical Options to be processed.
+func (h *Header) PartitionCritical(known map[uint16]bool) (supported, unsupported []*Option) {
+	for _, o := range h.Options {
+		if !o.FlagCritical {
+			continue
+		}
+
+		if known[o.OptionClass] {
+			supported = append(supported, o)
+		} else {
+			unsupported = append(unsupported, o)
+		}
+	}
+
+	return supported, unsupported
+}
+
+// VNIAllowed reports whether the VNI of a Header is present in allowed, such
+// as when a gateway only accepts packets for certain tenants.
+func (h *Header) VNIAllowed(allowed map[VNI]bool) bool {
+	return allowed[h.VNI]
+}
+
+// VNIInRange reports whether the VNI of a Header is within the inclusive
+// range from lo to hi.
+func (h *Header) VNIInRange(lo, hi VNI) bool {
+	return h.VNI >= lo && h.VNI <= hi
+}
+
+// AddOption appends an Option to a Header.  If the Option is critical, the
+// Header's FlagCritical is also set, as required by the Geneve draft.
+//
+// Removing Options never clears FlagCritical, because doing so would require
+// checking every remaining Option.
+func (h *Header) AddOption(o *Option) {
+	h.Options = append(h.Options, o)
+	if o.FlagCritical {
+		h.FlagCritical = true
+	}
+}
+
+// SetOption replaces the first Option in a Header with the same OptionClass
+// and Type as o, or appends o if no such Option exists.  As with AddOption,
+// the Header's FlagCritical is set if o is critical.
+func (h *Header) SetOption(o *Option) {
+	if o.FlagCritical {
+		h.FlagCritical = true
+	}
+
+	for i, ho := range h.Options {
+		if ho.OptionClass == o.OptionClass && ho.Type == o.Type {
+			h.Options[i] = o
+			return
+		}
+	}
+
+	h.Options = append(h.Options, o)
+}
+
+// RemoveOption removes the first Option in a Header with the specified
+// OptionClass and Type, and reports whether an Option was removed.  As
+// described for AddOption, FlagCritical is never cleared.
+func (h *Header) RemoveOption(class uint16, typ uint8) bool {
+	for i, o := range h.Options {
+		if o.OptionClass == class && o.Type == typ {
+			copy(h.Options[i:], h.Options[i+1:])
+
+			// Clear the duplicated final pointer so that the removed
+			// Option can be garbage collected
+			n := len(h.Options) - 1
+			h.Options[n] = nil
+			h.Options = h.Options[:n]
+			return true
+		}
+	}
+
+	return false
+}
+
+// OptionsLazy returns the Options of a Header.  If the Header was decoded
+// with DecodeOptions.LazyOptions, the Options are parsed on the first call
+// and stored in h.Options for subsequent calls, applying the same checks as
+// the DecodeOptions would have during decoding.  Otherwise, h.Options is
+// returned.
+func (h *Header) OptionsLazy() (Options, error) {
+	if h.rawOptions == nil {
+		return h.Options, nil
+	}
+
+	n, err := checkOptions(h.rawOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := parseOptionsArea(nil, h.rawOptions, n, h.lazyOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	h.Options = opts
+	h.rawOptions = nil
+	h.lazyOpts = nil
+
+	return h.Options, nil
+}
+
+// Raw returns the binary form of a Header, including its options area but
+// not its payload, exactly as it was received.  Raw returns nil unless the
+// Header was decoded with DecodeOptions.KeepRaw.  The binary form is copied
+// from the input during decoding, and is not affected by later modifications
+// to the input or the Header.
+func (h *Header) Raw() []byte {
+	return h.raw
+}
+
+// Canonical marshals a Header into a normalized binary form suitable for
+// computing a hash or message authentication code.  Options are sorted into
+// canonical order, with Options of the same OptionClass and Type ordered by
+// their critical flag and then their Data, and all reserved fields are zero.
+//
+// The canonical form is not the wire form of a Header, which must preserve
+// the order of its Options, and must not be transmitted.
+func (h *Header) Canonical() ([]byte, error) {
+	opts := make(Options, len(h.Options))
+	copy(opts, h.Options)
+
+	sort.SliceStable(opts, func(i, j int) bool {
+		a, b := opts[i], opts[j]
+		if a.OptionClass != b.OptionClass {
+			return a.OptionClass < b.OptionClass
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.FlagCritical != b.FlagCritical {
+			return !a.FlagCritical
+		}
+
+		return bytes.Compare(a.Data, b.Data) < 0
+	})
+
+	ch := *h
+	ch.Options = opts
+	ch.ClearReserved()
+
+	return ch.MarshalBinary()
+}
+
+// OptionsCanonical reports whether the Options of a Header are already in
+// canonical order, as described by Options.IsSorted, so that sorting them
+// would not change the binary form of the Header.  Options with the same
+// OptionClass and Type are not compared.
+func (h *Header) OptionsCanonical() bool {
+	return h.Options.IsSorted()
+}
+
+// FindOption returns the first Option in a Header with the specified
+// OptionClass and Type, or nil if no such Option exists.
+func (h *Header) FindOption(class uint16, typ uint8) *Option {
+	for _, o := range h.Options {
+		if o.OptionClass == class && o.Type == typ {
+			return o
+		}
+	}
+
+	return nil
+}
+
+// FindOptionData finds the first Option in h with the specified OptionClass
+// and Type, and decodes its Data into a value of type T using encoding/binary
+// in big endian byte order, as is conventional for network protocols.
+//
+// If no such Option exists, the zero value of T and false are returned.  If
+// the Option is found but T is not a fixed-size type, or its size does not
+// exactly match the length of the Option's Data, true and an error are
+// returned.
+func FindOptionData[T any](h *Header, class uint16, typ uint8) (T, bool, error) {
+	var v T
+	o := h.FindOption(class, typ)
+	if o == nil {
+		return v, false, nil
+	}
+
+	if binary.Size(v) != len(o.Data) {
+		return v, true, errOptionDataSize
+	}
+
+	if err := binary.Read(bytes.NewReader(o.Data), binary.BigEndian, &v); err != nil {
+		return v, true, err
+	}
+
+	return v, true, nil
+}
+
+// MatchOptionShape reports whether the Options of a Header match expected
+// exactly and in order, ignoring their Data.  This is useful for verifying
+// the structure of a Header whose Option data may vary.  Use
+// OptionShapeMismatch to determine why the Options do not match.
+func (h *Header) MatchOptionShape(expected []OptionShape) bool {
+	return h.OptionShapeMismatch(expected) == nil
+}
+
+// OptionShapeMismatch is like MatchOptionShape, but returns an error which
+// describes the first difference between the Options of a Header and
+// expected, or nil if they match.
+func (h *Header) OptionShapeMismatch(expected []OptionShape) error {
+	if len(h.Options) != len(expected) {
+		return fmt.Errorf("expected %d options, but got %d", len(expected), len(h.Options))
+	}
+
+	for i, o := range h.Options {
+		got := OptionShape{
+			Class:    o.OptionClass,
+			Type:     o.Type,
+			Critical: o.FlagCritical,
+		}
+
+		if want := expected[i]; got != want {
+			return fmt.Errorf("option %d: expected %+v, but got %+v", i, want, got)
+		}
+	}
+
+	return nil
+}
+
+// UpdateOption finds the first Option in a Header with the specified
+// OptionClass and Type, and replaces its Data with the result of calling fn
+// with a copy of that Data.  UpdateOption reports whether an Option was
+// updated: if no Option is found, or the Data returned by fn could not be
+// marshaled because its length is not a multiple of 4 or is too long, the
+// Option is left unchanged and false is returned.
+func (h *Header) UpdateOption(class uint16, typ uint8, fn func(data []byte) []byte) bool {
+	o := h.FindOption(class, typ)
+	if o == nil {
+		return false
+	}
+
+	data := make([]byte, len(o.Data))
+	copy(data, o.Data)
+
+	uo := *o
+	uo.Data = fn(data)
+	if err := uo.validate(); err != nil {
+		return false
+	}
+
+	o.Data = uo.Data
+	return true
+}
+
+// EncodedOptionsLen returns the value, in 4 byte units, which MarshalBinary
+// will write to the options length field of a Header.  An error is returned
+// if the Options are invalid, or too long to be described by the field.
+func (h *Header) EncodedOptionsLen() (int, error) {
+	ol, err := h.optionsLen()
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := optionsLenField(ol)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(f), nil
+}
+
+// RemainingOptionsBytes returns the number of bytes of Options which can
+// still be added to a Header before its Options exceed MaxHeaderOptionsLen.
+// An Option fits if its length in binary form, 4 bytes plus the length of its
+// Data, is no greater than the result.  The result is negative if the Options
+// of a Header are already too long.
+func (h *Header) RemainingOptionsBytes() int {
+	return MaxHeaderOptionsLen - OptionsLength(h.Options)
+}
+
+// OptionByteRange returns the offsets of the start and end of the Option at
+// index within the binary form of a Header produced by MarshalBinary, such
+// that b[start:end] contains the Option.  The Option's Data begins 4 bytes
+// after start.  This is useful for modifying a single Option in place in an
+// existing buffer.  If index is out of range, ok is false.
+//
+// The Options are not validated; if they cannot be marshaled, the offsets
+// are meaningless.
+func (h *Header) OptionByteRange(index int) (start, end int, ok bool) {
+	if index < 0 || index >= len(h.Options) {
+		return 0, 0, false
+	}
+
+	start = headerLen + OptionsLength(h.Options[:index])
+	end = start + optionHeaderLen + len(h.Options[index].Data)
+
+	return start, end, true
+}
+
+// Clone returns a deep copy of a Header.  The Options of the copy, and their
+// Data, do not share memory with the original Header, so either may be
+// modified without affecting the other.
+func (h *Header) Clone() *Header {
+	ch := *h
+	ch.raw = bytes.Clone(h.raw)
+	ch.rawOptions = bytes.Clone(h.rawOptions)
+
+	if h.Options != nil {
+		ch.Options = make(Options, len(h.Options))
+		for i, o := range h.Options {
+			ch.Options[i] = copyOption(o)
+		}
+	}
+
+	return &ch
+}
+
+// Reply returns a deep copy of a Header, as produced by Clone, with FlagOAM
+// set.  The VNI, ProtocolType, and Options are retained, so the Header may be
+// used as the basis for an echo response to an OAM packet, such as a tunnel
+// liveness probe.  Any further changes required by a specific OAM protocol
+// must be made by the caller.
+//
+// The reply is a new Header, so its Raw method returns nil, and its reserved
+// fields are zeroed as described by ClearReserved.
+func (h *Header) Reply() *Header {
+	rh := h.Clone()
+	rh.FlagOAM = true
+	rh.raw = nil
+	rh.ClearReserved()
+
+	return rh
+}
+
+// WireEqual reports whether h and other marshal to identical binary forms,
+// even if their fields differ in ways which do not affect the wire, such as
+// nil and empty Options.  If either Header cannot be marshaled, an error is
+// returned.
+func (h *Header) WireEqual(other *Header) (bool, error) {
+	a, err := h.MarshalBinary()
+	if err != nil {
+		return false, err
+	}
+
+	b, err := other.MarshalBinary()
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(a, b), nil
+}
+
+// ClearReserved zeroes the reserved fields of a Header, as is required when
+// sending a Header which was not received from another device.
+func (h *Header) ClearReserved() {
+	h.FlagsReserved = 0
+	h.Reserved = 0
+}
+
+// CopyReservedFrom sets the reserved fields of a Header to those of other,
+// such as when a transit device rewrites a received Header and must preserve
+// reserved fields it does not understand.
+func (h *Header) CopyReservedFrom(other *Header) {
+	h.FlagsReserved = other.FlagsReserved
+	h.Reserved = other.Reserved
 }
@@ -1,8 +1,12 @@
 package geneve
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"hash/fnv"
 	"io"
+	"math/rand"
 	"reflect"
 	"testing"
 )
@@ -219,6 +223,7 @@ func TestHeaderUnmarshalBinary(t *testing.T) {
 		desc string
 		h    *Header
 		b    []byte
+		n    int
 		err  error
 	}{
 		{
@@ -237,6 +242,25 @@ func TestHeaderUnmarshalBinary(t *testing.T) {
 			},
 			err: io.ErrUnexpectedEOF,
 		},
+		{
+			desc: "final option extends past declared options length",
+			b: []byte{
+				// Header
+				0x02,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option, declaring 8 bytes of data
+				0x00, 0x01,
+				0x02,
+				0x02,
+				0, 1, 2, 3,
+				// Payload
+				4, 5, 6, 7,
+			},
+			err: errOptionsMisaligned,
+		},
 		{
 			desc: "flag OAM OK",
 			b: []byte{
@@ -318,12 +342,38 @@ func TestHeaderUnmarshalBinary(t *testing.T) {
 				0x01,
 				0, 1, 2, 3,
 			},
+			n: 1,
 			h: &Header{
 				Options: []*Option{{
 					OptionClass:  0x0001,
 					FlagCritical: true,
 					Type:         0x02,
 					Data:         []byte{0, 1, 2, 3},
+					DataLen:      4,
+				}},
+			},
+		},
+		{
+			desc: "one empty Option OK",
+			b: []byte{
+				// Header
+				0x01,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x82,
+				0x00,
+			},
+			n: 1,
+			h: &Header{
+				Options: []*Option{{
+					OptionClass:  0x0001,
+					FlagCritical: true,
+					Type:         0x02,
+					Data:         []byte{},
 				}},
 			},
 		},
@@ -347,6 +397,7 @@ func TestHeaderUnmarshalBinary(t *testing.T) {
 				0x02,
 				4, 5, 6, 7, 8, 9, 10, 11,
 			},
+			n: 2,
 			h: &Header{
 				Options: []*Option{
 					{
@@ -354,11 +405,13 @@ func TestHeaderUnmarshalBinary(t *testing.T) {
 						FlagCritical: true,
 						Type:         0x02,
 						Data:         []byte{0, 1, 2, 3},
+						DataLen:      4,
 					},
 					{
 						OptionClass: 0x0002,
 						Type:        0x04,
 						Data:        []byte{4, 5, 6, 7, 8, 9, 10, 11},
+						DataLen:     8,
 					},
 				},
 			},
@@ -383,6 +436,7 @@ func TestHeaderUnmarshalBinary(t *testing.T) {
 				0x02,
 				4, 5, 6, 7, 8, 9, 10, 11,
 			},
+			n: 2,
 			h: &Header{
 				Version:      Version,
 				FlagOAM:      true,
@@ -395,11 +449,13 @@ func TestHeaderUnmarshalBinary(t *testing.T) {
 						FlagCritical: true,
 						Type:         0x02,
 						Data:         []byte{0, 1, 2, 3},
+						DataLen:      4,
 					},
 					{
 						OptionClass: 0x0002,
 						Type:        0x04,
 						Data:        []byte{4, 5, 6, 7, 8, 9, 10, 11},
+						DataLen:     8,
 					},
 				},
 			},
@@ -418,6 +474,12 @@ func TestHeaderUnmarshalBinary(t *testing.T) {
 			continue
 		}
 
+		// Verify the number of Options directly, so that a count error
+		// cannot be masked by the comparison of the entire Header
+		if want, got := tt.n, len(h.Options); want != got {
+			t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+		}
+
 		if want, got := tt.h, h; !reflect.DeepEqual(want, got) {
 			t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
 		}
@@ -483,11 +545,13 @@ func TestHeader_unmarshalBinaryOffset(t *testing.T) {
 						FlagCritical: true,
 						Type:         0x02,
 						Data:         []byte{0, 1, 2, 3},
+						DataLen:      4,
 					},
 					{
 						OptionClass: 0x0002,
 						Type:        0x04,
 						Data:        []byte{4, 5, 6, 7, 8, 9, 10, 11},
+						DataLen:     8,
 					},
 				},
 			},
@@ -512,3 +576,2789 @@ func TestHeader_unmarshalBinaryOffset(t *testing.T) {
 		}
 	}
 }
+
+func TestHeaderOptionClassCounts(t *testing.T) {
+	tests := []struct {
+		desc   string
+		h      *Header
+		counts map[uint16]int
+	}{
+		{
+			desc:   "no options",
+			h:      &Header{},
+			counts: map[uint16]int{},
+		},
+		{
+			desc: "multiple classes OK",
+			h: &Header{
+				Options: []*Option{
+					{OptionClass: 0x0001},
+					{OptionClass: 0x0002},
+					{OptionClass: 0x0001, Type: 0x01},
+				},
+			},
+			counts: map[uint16]int{
+				0x0001: 2,
+				0x0002: 1,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.counts, tt.h.OptionClassCounts(); !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected counts:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestWalkOptions(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		o    []Option
+		off  int
+		err  error
+	}{
+		{
+			desc: "input bytes too short for header",
+			b:    make([]byte, headerLen-1),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "option data extends past options area",
+			b: []byte{
+				// Header
+				0x01,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x01,
+				// Payload
+				1, 2, 3, 4,
+			},
+			err: errOptionsMisaligned,
+		},
+		{
+			desc: "no options OK",
+			b: []byte{
+				// Header
+				0x00,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Payload
+				1, 2, 3,
+			},
+			off: 8,
+		},
+		{
+			desc: "empty option with unaligned payload OK",
+			b: []byte{
+				// Header
+				0x01,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x82,
+				0x00,
+				// Payload
+				1, 2, 3,
+			},
+			o: []Option{{
+				OptionClass:  0x0001,
+				FlagCritical: true,
+				Type:         0x02,
+				Data:         []byte{},
+			}},
+			off: 12,
+		},
+		{
+			desc: "two options OK",
+			b: []byte{
+				// Header
+				0x05,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x82,
+				0x01,
+				0, 1, 2, 3,
+				// Option
+				0x00, 0x02,
+				0x04,
+				0x02,
+				4, 5, 6, 7, 8, 9, 10, 11,
+				// Payload
+				1, 2, 3, 4,
+			},
+			o: []Option{
+				{
+					OptionClass:  0x0001,
+					FlagCritical: true,
+					Type:         0x02,
+					Data:         []byte{0, 1, 2, 3},
+					DataLen:      4,
+				},
+				{
+					OptionClass: 0x0002,
+					Type:        0x04,
+					Data:        []byte{4, 5, 6, 7, 8, 9, 10, 11},
+					DataLen:     8,
+				},
+			},
+			off: 28,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var opts []Option
+		off, err := WalkOptions(tt.b, func(o *Option) error {
+			opts = append(opts, *o)
+			return nil
+		})
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.o, opts; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.off, off; want != got {
+			t.Fatalf("unexpected offset:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestNewOAMPacket(t *testing.T) {
+	if _, err := NewOAMPacket(MaxVNI + 1); err != errInvalidVNI {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidVNI, err)
+	}
+
+	h, err := NewOAMPacket(0x00030201)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	want := []byte{
+		0x00,
+		0x80,
+		0x00, 0x00,
+		0x03, 0x02, 0x01,
+		0x00,
+	}
+	if got := b; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderFlowHash(t *testing.T) {
+	h := &Header{
+		FlagOAM:      true,
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00bbeeff,
+		Options: []*Option{{
+			OptionClass: 0x0001,
+		}},
+	}
+
+	f := fnv.New64a()
+	_, _ = f.Write([]byte{0xbb, 0xee, 0xff, 0x65, 0x58})
+
+	if want, got := f.Sum64(), h.FlowHash(); want != got {
+		t.Fatalf("unexpected hash:\n- want: %#x\n-  got: %#x", want, got)
+	}
+
+	// Flags and options must not affect the hash
+	h2 := &Header{
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00bbeeff,
+	}
+	if want, got := h.FlowHash(), h2.FlowHash(); want != got {
+		t.Fatalf("unexpected hash for equivalent flow:\n- want: %#x\n-  got: %#x", want, got)
+	}
+}
+
+func TestHeaderUnmarshalBinaryDoesNotAliasInput(t *testing.T) {
+	b := []byte{
+		// Header
+		0x02,
+		0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+	}
+
+	h := new(Header)
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	// Mutating the input buffer must not affect the decoded Header
+	for i := range b {
+		b[i] = 0xff
+	}
+
+	if want, got := []byte{0, 1, 2, 3}, h.Options[0].Data; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected Option data:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func Test_optionsLenField(t *testing.T) {
+	tests := []struct {
+		desc string
+		n    int
+		f    byte
+		err  error
+	}{
+		{
+			desc: "no options",
+			n:    0,
+			f:    0,
+		},
+		{
+			desc: "unaligned options",
+			n:    6,
+			err:  errOptionsUnaligned,
+		},
+		{
+			desc: "aligned options OK",
+			n:    20,
+			f:    5,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		f, err := optionsLenField(tt.n)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.f, f; want != got {
+			t.Fatalf("unexpected field:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderReset(t *testing.T) {
+	h := &Header{
+		Version:      1,
+		FlagOAM:      true,
+		FlagCritical: true,
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00bbeeff,
+		Options: []*Option{
+			{OptionClass: 0x0001},
+			{OptionClass: 0x0002},
+		},
+	}
+
+	h.Reset()
+
+	if want, got := (&Header{Options: Options{}}), h; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if want, got := 2, cap(h.Options); want != got {
+		t.Fatalf("unexpected Options capacity:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		h    *Header
+		rest []byte
+		err  error
+	}{
+		{
+			desc: "empty",
+			err:  io.EOF,
+		},
+		{
+			desc: "too short for header",
+			b:    make([]byte, headerLen-1),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "too short for options",
+			b: []byte{
+				0x01,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				0x00, 0x01,
+			},
+			err: io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "OK",
+			b: []byte{
+				// Header
+				0x02,
+				0x00,
+				0x65, 0x58,
+				0x03, 0x02, 0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x01,
+				0, 1, 2, 3,
+				// Payload
+				1, 2, 3,
+			},
+			h: &Header{
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          0x00030201,
+				Options: []*Option{{
+					OptionClass: 0x0001,
+					Type:        0x02,
+					Data:        []byte{0, 1, 2, 3},
+					DataLen:     4,
+				}},
+			},
+			rest: []byte{1, 2, 3},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		r := bufio.NewReader(bytes.NewReader(tt.b))
+		h, err := ReadHeader(r)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.h, h; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("failed to read payload: %v", err)
+		}
+
+		if want, got := tt.rest, rest; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected payload:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderUnmarshalBinaryPreallocatesOptions(t *testing.T) {
+	// Maximum length options area containing one large Option
+	b := append([]byte{
+		// Header
+		0x3f,
+		0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00,
+		0x00,
+	}, make([]byte, MaxHeaderOptionsLen)...)
+	b[headerLen+3] = 0x1f
+
+	h := new(Header)
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	// One large Option followed by empty Options filling the remainder
+	if want, got := 1+(MaxHeaderOptionsLen-128)/optionHeaderLen, len(h.Options); want != got {
+		t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Options must be allocated exactly once, without growing the slice
+	if want, got := len(h.Options), cap(h.Options); want != got {
+		t.Fatalf("unexpected Options capacity:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderMarshalOptions(t *testing.T) {
+	tests := []struct {
+		desc string
+		h    *Header
+		b    []byte
+		err  error
+	}{
+		{
+			desc: "invalid option",
+			h: &Header{
+				Options: []*Option{{
+					Data: []byte{0},
+				}},
+			},
+			err: errInvalidOptionDataLength,
+		},
+		{
+			desc: "options too long",
+			h: &Header{
+				Options: []*Option{
+					{Data: make([]byte, maxOptionLength*4)},
+					{Data: make([]byte, maxOptionLength*4)},
+				},
+			},
+			err: errOptionsTooLong,
+		},
+		{
+			desc: "no options OK",
+			h:    &Header{},
+			b:    []byte{},
+		},
+		{
+			desc: "two options OK",
+			h: &Header{
+				// Fixed header fields must not be present in output
+				FlagOAM: true,
+				VNI:     0x00bbeeff,
+				Options: []*Option{
+					{
+						OptionClass:  0x0001,
+						FlagCritical: true,
+						Type:         0x02,
+						Data:         []byte{0, 1, 2, 3},
+					},
+					{
+						OptionClass: 0x0002,
+						Type:        0x04,
+					},
+				},
+			},
+			b: []byte{
+				// Option
+				0x00, 0x01,
+				0x82,
+				0x01,
+				0, 1, 2, 3,
+				// Option
+				0x00, 0x02,
+				0x04,
+				0x00,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		b, err := tt.h.MarshalOptions()
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if b == nil {
+			t.Fatal("MarshalOptions returned nil byte slice")
+		}
+
+		if want, got := tt.b, b; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected bytes:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+// TestHeaderSpecVectors verifies headers transcribed from the field layouts
+// in RFC 8926 (the published form of the Geneve draft).  The RFC contains no
+// complete example packets, so each vector sets every field of the layout it
+// cites to a distinct value, and is written out bit by bit from the figure.
+func TestHeaderSpecVectors(t *testing.T) {
+	tests := []struct {
+		desc string
+		h    *Header
+		b    []byte
+	}{
+		{
+			// RFC 8926, Section 3.4, Figure 3: Ver (2 bits) and Opt Len
+			// (6 bits) share the first byte, O and C are the two high bits
+			// of the second byte, and the VNI is followed by an 8 bit
+			// reserved field.
+			desc: "RFC 8926, Section 3.4, OAM frame with no options",
+			h: &Header{
+				FlagOAM:      true,
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          0x00123456,
+			},
+			b: []byte{
+				0x00,
+				0x80,
+				0x65, 0x58,
+				0x12, 0x34, 0x56,
+				0x00,
+			},
+		},
+		{
+			// RFC 8926, Section 3.4: Opt Len is expressed in 4 byte
+			// multiples, excluding the 8 byte fixed header.
+			// RFC 8926, Section 3.5, Figure 4: the critical bit is the
+			// high bit of the Type field, followed by 3 reserved bits and
+			// a 5 bit Length in 4 byte multiples, excluding the option
+			// header.
+			desc: "RFC 8926, Sections 3.4 and 3.5, critical option",
+			h: &Header{
+				FlagCritical: true,
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          0x00000001,
+				Options: []*Option{{
+					OptionClass:  0x0102,
+					FlagCritical: true,
+					Type:         0x03,
+					Data:         []byte{0xde, 0xad, 0xbe, 0xef},
+					DataLen:      4,
+				}},
+			},
+			b: []byte{
+				// Header
+				0x02,
+				0x40,
+				0x65, 0x58,
+				0x00, 0x00, 0x01,
+				0x00,
+				// Option
+				0x01, 0x02,
+				0x83,
+				0x01,
+				0xde, 0xad, 0xbe, 0xef,
+			},
+		},
+		{
+			// RFC 8926, Section 3.4: Protocol Type uses Ethertype values,
+			// and Section 3.5: an option with a Length of 0 carries no
+			// variable length data.
+			desc: "RFC 8926, Sections 3.4 and 3.5, IPv4 with empty option",
+			h: &Header{
+				ProtocolType: ProtocolTypeIPv4,
+				VNI:          MaxVNI,
+				Options: []*Option{{
+					OptionClass: 0xffff,
+					Type:        0x7f,
+					Data:        []byte{},
+				}},
+			},
+			b: []byte{
+				// Header
+				0x01,
+				0x00,
+				0x08, 0x00,
+				0xff, 0xff, 0xff,
+				0x00,
+				// Option
+				0xff, 0xff,
+				0x7f,
+				0x00,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		b, err := tt.h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal Header: %v", err)
+		}
+
+		if want, got := tt.b, b; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected bytes:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		h := new(Header)
+		if err := h.UnmarshalBinary(tt.b); err != nil {
+			t.Fatalf("failed to unmarshal Header: %v", err)
+		}
+
+		if want, got := tt.h, h; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderSetProtocol(t *testing.T) {
+	h := new(Header)
+
+	h.SetProtocolFromEtherType(0x86dd)
+	if want, got := ProtocolTypeIPv6, h.ProtocolType; want != got {
+		t.Fatalf("unexpected ProtocolType:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	h.SetProtocolEthernet()
+	if want, got := ProtocolTypeEthernet, h.ProtocolType; want != got {
+		t.Fatalf("unexpected ProtocolType:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func BenchmarkHeaderMarshalBinaryNoOptions(b *testing.B) {
+	h := &Header{
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00bbeeff,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := h.MarshalBinary(); err != nil {
+			b.Fatalf("failed to marshal Header: %v", err)
+		}
+	}
+}
+
+func BenchmarkHeaderMarshalBinaryUncheckedNoOptions(b *testing.B) {
+	h := &Header{
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00bbeeff,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := h.MarshalBinaryUnchecked(); err != nil {
+			b.Fatalf("failed to marshal Header: %v", err)
+		}
+	}
+}
+
+func BenchmarkHeaderUnmarshalBinaryNoOptions(b *testing.B) {
+	buf := []byte{
+		0x00,
+		0x00,
+		0x65, 0x58,
+		0xbb, 0xee, 0xff,
+		0x00,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var h Header
+		if err := h.UnmarshalBinary(buf); err != nil {
+			b.Fatalf("failed to unmarshal Header: %v", err)
+		}
+	}
+}
+
+// TestHeaderGolden is the canonical wire format contract for this package.
+// Any change to the encoding of a Header must be made deliberately, by
+// updating the golden bytes in this test.
+func TestHeaderGolden(t *testing.T) {
+	golden := []byte{
+		// Header
+		0x05,
+		0xc0,
+		0x86, 0xdd,
+		0xa1, 0xb2, 0xc3,
+		0x00,
+		// Option
+		0x01, 0x02,
+		0x83,
+		0x01,
+		0xde, 0xad, 0xbe, 0xef,
+		// Option
+		0xfe, 0xdc,
+		0x7f,
+		0x02,
+		0, 1, 2, 3, 4, 5, 6, 7,
+	}
+
+	h := &Header{
+		Version:      Version,
+		FlagOAM:      true,
+		FlagCritical: true,
+		ProtocolType: ProtocolTypeIPv6,
+		VNI:          0x00a1b2c3,
+		Options: []*Option{
+			{
+				OptionClass:  0x0102,
+				FlagCritical: true,
+				Type:         0x03,
+				Data:         []byte{0xde, 0xad, 0xbe, 0xef},
+				DataLen:      4,
+			},
+			{
+				OptionClass: 0xfedc,
+				Type:        0x7f,
+				Data:        []byte{0, 1, 2, 3, 4, 5, 6, 7},
+				DataLen:     8,
+			},
+		},
+	}
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	if want, got := golden, b; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected golden bytes:\n- want: %#v\n-  got: %#v", want, got)
+	}
+
+	h2 := new(Header)
+	if err := h2.UnmarshalBinary(golden); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if want, got := h, h2; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected golden Header:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderIsMinimal(t *testing.T) {
+	tests := []struct {
+		desc string
+		h    *Header
+		ok   bool
+	}{
+		{
+			desc: "nil options",
+			h:    &Header{},
+			ok:   true,
+		},
+		{
+			desc: "empty options",
+			h:    &Header{Options: Options{}},
+			ok:   true,
+		},
+		{
+			desc: "one option",
+			h:    &Header{Options: Options{{}}},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.ok, tt.h.IsMinimal(); want != got {
+			t.Fatalf("unexpected IsMinimal:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := !tt.ok, tt.h.HasOptions(); want != got {
+			t.Fatalf("unexpected HasOptions:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		b, err := tt.h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal Header: %v", err)
+		}
+
+		if want, got := tt.ok, len(b) == headerLen; want != got {
+			t.Fatalf("unexpected minimal encoding:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderBigEndian(t *testing.T) {
+	h := &Header{
+		ProtocolType: 0x6558,
+		VNI:          0x00010203,
+		Options: []*Option{{
+			OptionClass: 0x0a0b,
+		}},
+	}
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	tests := []struct {
+		desc string
+		b    []byte
+		want []byte
+	}{
+		{
+			desc: "protocol type",
+			b:    b[2:4],
+			want: []byte{0x65, 0x58},
+		},
+		{
+			desc: "VNI",
+			b:    b[4:7],
+			want: []byte{0x01, 0x02, 0x03},
+		},
+		{
+			desc: "option class",
+			b:    b[8:10],
+			want: []byte{0x0a, 0x0b},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.want, tt.b; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected %s bytes:\n- want: %v\n-  got: %v", tt.desc, want, got)
+		}
+	}
+
+	h2 := new(Header)
+	if err := h2.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if want, got := h.ProtocolType, h2.ProtocolType; want != got {
+		t.Fatalf("unexpected ProtocolType:\n- want: %#x\n-  got: %#x", want, got)
+	}
+	if want, got := h.VNI, h2.VNI; want != got {
+		t.Fatalf("unexpected VNI:\n- want: %#x\n-  got: %#x", want, got)
+	}
+	if want, got := h.Options[0].OptionClass, h2.Options[0].OptionClass; want != got {
+		t.Fatalf("unexpected OptionClass:\n- want: %#x\n-  got: %#x", want, got)
+	}
+}
+
+func TestHeaderMarshalBinaryAt(t *testing.T) {
+	h := &Header{
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00030201,
+		Options: []*Option{{
+			OptionClass: 0x0001,
+			Type:        0x02,
+			Data:        []byte{0, 1, 2, 3},
+		}},
+	}
+
+	want, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	tests := []struct {
+		desc string
+		h    *Header
+		b    []byte
+		off  int
+		end  int
+		err  error
+	}{
+		{
+			desc: "invalid VNI",
+			h:    &Header{VNI: MaxVNI + 1},
+			b:    make([]byte, headerLen),
+			err:  errInvalidVNI,
+		},
+		{
+			desc: "negative offset",
+			h:    h,
+			b:    make([]byte, 32),
+			off:  -1,
+			err:  io.ErrShortBuffer,
+		},
+		{
+			desc: "buffer too short",
+			h:    h,
+			b:    make([]byte, 20),
+			off:  5,
+			err:  io.ErrShortBuffer,
+		},
+		{
+			desc: "exact length OK",
+			h:    h,
+			b:    make([]byte, 16),
+			end:  16,
+		},
+		{
+			desc: "offset OK",
+			h:    h,
+			b:    bytes.Repeat([]byte{0xff}, 32),
+			off:  4,
+			end:  20,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		end, err := tt.h.MarshalBinaryAt(tt.b, tt.off)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.end, end; want != got {
+			t.Fatalf("unexpected end offset:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if got := tt.b[tt.off:end]; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected bytes:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestNewHeaderWithOptions(t *testing.T) {
+	tests := []struct {
+		desc string
+		vni  VNI
+		opts []*Option
+		h    *Header
+		err  error
+	}{
+		{
+			desc: "invalid VNI",
+			vni:  MaxVNI + 1,
+			err:  errInvalidVNI,
+		},
+		{
+			desc: "invalid option",
+			opts: []*Option{{Type: maxOptionType + 1}},
+			err:  errInvalidOptionType,
+		},
+		{
+			desc: "options too long",
+			opts: []*Option{
+				{Data: make([]byte, maxOptionLength*4)},
+				{Data: make([]byte, maxOptionLength*4)},
+			},
+			err: errOptionsTooLong,
+		},
+		{
+			desc: "no options OK",
+			vni:  MaxVNI,
+			h: &Header{
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          MaxVNI,
+			},
+		},
+		{
+			desc: "options OK",
+			vni:  1,
+			opts: []*Option{
+				{OptionClass: 0x0001},
+				{OptionClass: 0x0002},
+			},
+			h: &Header{
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          1,
+				Options: []*Option{
+					{OptionClass: 0x0001},
+					{OptionClass: 0x0002},
+				},
+			},
+		},
+		{
+			desc: "critical option OK",
+			vni:  1,
+			opts: []*Option{
+				{OptionClass: 0x0001},
+				{OptionClass: 0x0002, FlagCritical: true},
+			},
+			h: &Header{
+				FlagCritical: true,
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          1,
+				Options: []*Option{
+					{OptionClass: 0x0001},
+					{OptionClass: 0x0002, FlagCritical: true},
+				},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		h, err := NewHeaderWithOptions(tt.vni, ProtocolTypeEthernet, tt.opts...)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.h, h; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestDeclaredOptionsLen(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		n    int
+		err  error
+	}{
+		{
+			desc: "input bytes too short for header",
+			b:    make([]byte, headerLen-1),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "no options",
+			b:    make([]byte, headerLen),
+		},
+		{
+			desc: "options area not present OK",
+			b: []byte{
+				0x05,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+			},
+			n: 20,
+		},
+		{
+			desc: "maximum options length, version ignored",
+			b: []byte{
+				0xff,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+			},
+			n: MaxHeaderOptionsLen,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		n, err := DeclaredOptionsLen(tt.b)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.n, n; want != got {
+			t.Fatalf("unexpected options length:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderAppendOptions(t *testing.T) {
+	var (
+		o1 = &Option{OptionClass: 0x0001}
+		o2 = &Option{OptionClass: 0x0002}
+		o3 = &Option{OptionClass: 0x0003}
+	)
+
+	h1 := &Header{Options: []*Option{o1, o2}}
+	h2 := &Header{Options: []*Option{o3}}
+
+	opts := h2.AppendOptions(h1.AppendOptions(nil))
+	if want, got := []*Option{o1, o2, o3}, opts; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Option pointers are shared, not cloned
+	if opts[0] != o1 {
+		t.Fatal("AppendOptions did not share Option pointers")
+	}
+}
+
+func TestHeaderFlagsConsistent(t *testing.T) {
+	tests := []struct {
+		desc     string
+		h        *Header
+		critical bool
+		ok       bool
+	}{
+		{
+			desc: "no flag, no options",
+			h:    &Header{},
+			ok:   true,
+		},
+		{
+			desc: "flag, no options",
+			h: &Header{
+				FlagCritical: true,
+			},
+		},
+		{
+			desc: "no flag, critical option",
+			h: &Header{
+				Options: []*Option{
+					{OptionClass: 0x0001},
+					{OptionClass: 0x0002, FlagCritical: true},
+				},
+			},
+			critical: true,
+		},
+		{
+			desc: "flag, critical option",
+			h: &Header{
+				FlagCritical: true,
+				Options: []*Option{
+					{OptionClass: 0x0001, FlagCritical: true},
+				},
+			},
+			critical: true,
+			ok:       true,
+		},
+		{
+			desc: "no flag, non-critical option",
+			h: &Header{
+				Options: []*Option{
+					{OptionClass: 0x0001},
+				},
+			},
+			ok: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.critical, tt.h.HasCriticalOption(); want != got {
+			t.Fatalf("unexpected HasCriticalOption:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.ok, tt.h.FlagsConsistent(); want != got {
+			t.Fatalf("unexpected FlagsConsistent:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderAddOption(t *testing.T) {
+	h := new(Header)
+
+	h.AddOption(&Option{OptionClass: 0x0001})
+	if h.FlagCritical {
+		t.Fatal("non-critical Option set FlagCritical")
+	}
+
+	h.AddOption(&Option{OptionClass: 0x0002, FlagCritical: true})
+	if !h.FlagCritical {
+		t.Fatal("critical Option did not set FlagCritical")
+	}
+
+	if want, got := 2, len(h.Options); want != got {
+		t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderSetOption(t *testing.T) {
+	h := &Header{
+		Options: []*Option{
+			{OptionClass: 0x0001, Type: 0x01},
+			{OptionClass: 0x0001, Type: 0x02},
+		},
+	}
+
+	h.SetOption(&Option{OptionClass: 0x0001, Type: 0x02, Data: []byte{0, 1, 2, 3}})
+	h.SetOption(&Option{OptionClass: 0x0002, Type: 0x01, FlagCritical: true})
+
+	want := &Header{
+		FlagCritical: true,
+		Options: []*Option{
+			{OptionClass: 0x0001, Type: 0x01},
+			{OptionClass: 0x0001, Type: 0x02, Data: []byte{0, 1, 2, 3}},
+			{OptionClass: 0x0002, Type: 0x01, FlagCritical: true},
+		},
+	}
+
+	if got := h; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderMaxVNI(t *testing.T) {
+	b, err := (&Header{VNI: MaxVNI}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	if want, got := []byte{0xff, 0xff, 0xff}, b[4:7]; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected VNI bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := byte(0x00), b[7]; want != got {
+		t.Fatalf("unexpected reserved byte:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	h := new(Header)
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if want, got := VNI(MaxVNI), h.VNI; want != got {
+		t.Fatalf("unexpected VNI:\n- want: %#x\n-  got: %#x", want, got)
+	}
+
+	if _, err := (&Header{VNI: MaxVNI + 1}).MarshalBinary(); err != errInvalidVNI {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidVNI, err)
+	}
+}
+
+func TestHeaderUnmarshalBinaryOptionsNotNil(t *testing.T) {
+	// Maximum number of empty Options, with alternating classes
+	b := make([]byte, headerLen+MaxHeaderOptionsLen)
+	b[0] = 0x3f
+	for i := headerLen; i < len(b); i += optionHeaderLen {
+		b[i+1] = byte((i / optionHeaderLen) % 2)
+	}
+
+	h := new(Header)
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if want, got := MaxOptions, len(h.Options); want != got {
+		t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	for i, o := range h.Options {
+		if o == nil {
+			t.Fatalf("Option %d is nil", i)
+		}
+	}
+
+	// This is the worst case for a decoder, which must still perform a
+	// small, constant number of allocations, regardless of the number of
+	// Options
+	const ceiling = 4
+	allocs := testing.AllocsPerRun(100, func() {
+		var h Header
+		_ = h.UnmarshalBinary(b)
+	})
+	if allocs > ceiling {
+		t.Fatalf("too many allocations: %v > %v", allocs, ceiling)
+	}
+}
+
+func TestHeaderCanonical(t *testing.T) {
+	var (
+		a  = &Option{OptionClass: 0x0001, Type: 0x01}
+		b1 = &Option{OptionClass: 0x0002, Type: 0x01, Data: []byte{0, 0, 0, 1}}
+		b2 = &Option{OptionClass: 0x0002, Type: 0x01, Data: []byte{0, 0, 0, 0}}
+		b3 = &Option{OptionClass: 0x0002, Type: 0x01, FlagCritical: true}
+	)
+
+	h1 := &Header{
+		FlagCritical: true,
+		VNI:          1,
+		Options:      []*Option{b1, b3, a, b2},
+	}
+	h2 := &Header{
+		FlagCritical: true,
+		VNI:          1,
+		Options:      []*Option{b2, a, b3, b1},
+	}
+
+	c1, err := h1.Canonical()
+	if err != nil {
+		t.Fatalf("failed to canonicalize Header: %v", err)
+	}
+	c2, err := h2.Canonical()
+	if err != nil {
+		t.Fatalf("failed to canonicalize Header: %v", err)
+	}
+
+	if !bytes.Equal(c1, c2) {
+		t.Fatalf("canonical forms differ:\n- h1: %v\n- h2: %v", c1, c2)
+	}
+
+	want, err := (&Header{
+		FlagCritical: true,
+		VNI:          1,
+		Options:      []*Option{a, b2, b1, b3},
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	if got := c1; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected canonical form:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The original Header must not be reordered
+	if want, got := b1, h1.Options[0]; want != got {
+		t.Fatalf("Header Options were modified:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func BenchmarkHeaderUnmarshalBinaryManyOptions(b *testing.B) {
+	const n = 30
+
+	h := new(Header)
+	for i := 0; i < n; i++ {
+		h.Options = append(h.Options, &Option{
+			OptionClass: uint16(i),
+			Type:        0x01,
+			Data:        []byte{0, 1, 2, 3},
+		})
+	}
+
+	buf, err := h.MarshalBinary()
+	if err != nil {
+		b.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var h Header
+		if err := h.UnmarshalBinary(buf); err != nil {
+			b.Fatalf("failed to unmarshal Header: %v", err)
+		}
+	}
+}
+
+func TestHeaderUpdateOption(t *testing.T) {
+	tests := []struct {
+		desc  string
+		class uint16
+		fn    func(data []byte) []byte
+		data  []byte
+		ok    bool
+	}{
+		{
+			desc:  "not found",
+			class: 0x0002,
+			fn: func(data []byte) []byte {
+				return data
+			},
+			data: []byte{0, 1, 2, 3},
+		},
+		{
+			desc:  "unaligned data",
+			class: 0x0001,
+			fn: func(data []byte) []byte {
+				return append(data, 4)
+			},
+			data: []byte{0, 1, 2, 3},
+		},
+		{
+			desc:  "data too long",
+			class: 0x0001,
+			fn: func(_ []byte) []byte {
+				return make([]byte, (maxOptionLength*4)+4)
+			},
+			data: []byte{0, 1, 2, 3},
+		},
+		{
+			desc:  "in place OK",
+			class: 0x0001,
+			fn: func(data []byte) []byte {
+				data[0] = 0xff
+				return data
+			},
+			data: []byte{0xff, 1, 2, 3},
+			ok:   true,
+		},
+		{
+			desc:  "grow OK",
+			class: 0x0001,
+			fn: func(data []byte) []byte {
+				return append(data, 4, 5, 6, 7)
+			},
+			data: []byte{0, 1, 2, 3, 4, 5, 6, 7},
+			ok:   true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		h := &Header{
+			Options: []*Option{{
+				OptionClass: 0x0001,
+				Type:        0x01,
+				Data:        []byte{0, 1, 2, 3},
+			}},
+		}
+
+		ok := h.UpdateOption(tt.class, 0x01, tt.fn)
+		if want, got := tt.ok, ok; want != got {
+			t.Fatalf("unexpected UpdateOption result:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.data, h.FindOption(0x0001, 0x01).Data; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected Option data:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderEmptyOptions(t *testing.T) {
+	h := &Header{
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00030201,
+		Options:      []*Option{},
+	}
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	want := []byte{
+		0x00,
+		0x00,
+		0x65, 0x58,
+		0x03, 0x02, 0x01,
+		0x00,
+	}
+	if got := b; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	h2 := new(Header)
+	if err := h2.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if want, got := 0, len(h2.Options); want != got {
+		t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	b2, err := h2.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	if !bytes.Equal(b, b2) {
+		t.Fatalf("Header did not round trip:\n- want: %v\n-  got: %v", b, b2)
+	}
+}
+
+func TestHeaderEncodedOptionsLen(t *testing.T) {
+	tests := []struct {
+		desc string
+		h    *Header
+		n    int
+		err  error
+	}{
+		{
+			desc: "invalid option",
+			h: &Header{
+				Options: []*Option{{Data: []byte{0}}},
+			},
+			err: errInvalidOptionDataLength,
+		},
+		{
+			desc: "options too long",
+			h: &Header{
+				Options: []*Option{
+					{Data: make([]byte, maxOptionLength*4)},
+					{Data: make([]byte, maxOptionLength*4)},
+				},
+			},
+			err: errOptionsTooLong,
+		},
+		{
+			desc: "no options",
+			h:    &Header{},
+		},
+		{
+			desc: "two options",
+			h: &Header{
+				Options: []*Option{
+					{Data: []byte{0, 1, 2, 3}},
+					{Data: []byte{4, 5, 6, 7, 8, 9, 10, 11}},
+				},
+			},
+			n: 5,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		n, err := tt.h.EncodedOptionsLen()
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.n, n; want != got {
+			t.Fatalf("unexpected options length:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		b, err := tt.h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal Header: %v", err)
+		}
+
+		if want, got := n, int(b[0]&0x3f); want != got {
+			t.Fatalf("unexpected marshaled options length:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func Test_optionsLenFieldImpossible(t *testing.T) {
+	for _, n := range []int{-4, MaxHeaderOptionsLen + 4} {
+		if _, err := optionsLenField(n); err != errImpossibleLength {
+			t.Fatalf("unexpected error for length %d:\n- want: %v\n-  got: %v",
+				n, errImpossibleLength, err)
+		}
+	}
+}
+
+func TestHeaderAdversarialLengths(t *testing.T) {
+	// Every combination of declared options length and first option length,
+	// truncated at every possible length, must produce an error or a Header,
+	// but never a panic
+	for ol := 0; ol <= 0x3f; ol++ {
+		for dl := 0; dl <= 0xff; dl += 0x0f {
+			b := make([]byte, headerLen+MaxHeaderOptionsLen)
+			b[0] = byte(ol)
+			b[headerLen+3] = byte(dl)
+
+			for n := 0; n <= len(b); n += 3 {
+				decodeAll(b[:n])
+			}
+		}
+	}
+
+	// Random inputs with a fixed seed for reproducibility
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 10000; i++ {
+		b := make([]byte, r.Intn(headerLen+MaxHeaderOptionsLen+16))
+		_, _ = r.Read(b)
+
+		decodeAll(b)
+	}
+}
+
+// decodeAll decodes b using each decoding entry point in the package,
+// discarding the results.
+func decodeAll(b []byte) {
+	_ = new(Header).UnmarshalBinary(b)
+	_ = new(Header).Decode(b, &DecodeOptions{
+		AllowFutureVersion: true,
+		Strict:             true,
+		MaxOptionDataLen:   8,
+		BestEffort:         true,
+		NoCopy:             true,
+		KeepRaw:            true,
+	})
+
+	h := new(Header)
+	if err := h.Decode(b, &DecodeOptions{LazyOptions: true}); err == nil {
+		_, _ = h.OptionsLazy()
+	}
+
+	_, _ = WalkOptions(b, func(_ *Option) error { return nil })
+	_, _ = ValidateDatagram(b)
+	_, _, _ = Decapsulate(b)
+	_, _ = DeclaredOptionsLen(b)
+	_, _ = UnmarshalOptions(b)
+	_ = new(Option).UnmarshalBinary(b)
+}
+
+func TestHeaderOptionsConsumeDeclaredLength(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		n    int
+		err  error
+	}{
+		{
+			desc: "one option exactly consumes area",
+			b: []byte{
+				// Header
+				0x02,
+				0x00,
+				0x65, 0x58,
+				0x00, 0x00, 0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x01,
+				0x01,
+				0xff, 0xff, 0xff, 0xff,
+			},
+			n: 1,
+		},
+		{
+			desc: "two options exactly consume area",
+			b: []byte{
+				// Header
+				0x02,
+				0x00,
+				0x65, 0x58,
+				0x00, 0x00, 0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x00,
+			},
+			n: 2,
+		},
+		{
+			desc: "option data extends past declared area",
+			b: []byte{
+				// Header
+				0x01,
+				0x00,
+				0x65, 0x58,
+				0x00, 0x00, 0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x01,
+				0x01,
+				// Payload, not option data
+				0xff, 0xff, 0xff, 0xff,
+			},
+			err: errOptionsMisaligned,
+		},
+		{
+			desc: "second option extends past declared area",
+			b: []byte{
+				// Header
+				0x03,
+				0x00,
+				0x65, 0x58,
+				0x00, 0x00, 0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x01,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x02,
+				0xff, 0xff, 0xff, 0xff,
+				// Payload, not option data
+				0xff, 0xff, 0xff, 0xff,
+			},
+			err: errOptionsMisaligned,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		// Skipping non-critical Options must not hide an inconsistent
+		// options area
+		for _, opts := range []*DecodeOptions{
+			{AllowFutureVersion: true},
+			{BestEffort: true},
+			{NoCopy: true},
+		} {
+			h := new(Header)
+			if want, got := tt.err, h.Decode(tt.b, opts); want != got {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+			}
+
+			if want, got := tt.n, len(h.Options); want != got {
+				t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+			}
+		}
+	}
+}
+
+func TestFindOptionData(t *testing.T) {
+	h := &Header{
+		Options: []*Option{{
+			OptionClass: 0x0102,
+			Type:        0x01,
+			Data:        []byte{0xde, 0xad, 0xbe, 0xef},
+		}},
+	}
+
+	v, ok, err := FindOptionData[uint32](h, 0x0102, 0x01)
+	if err != nil {
+		t.Fatalf("failed to find option data: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected option to be found")
+	}
+	if want, got := uint32(0xdeadbeef), v; want != got {
+		t.Fatalf("unexpected value:\n- want: %#x\n-  got: %#x", want, got)
+	}
+
+	// Two big endian uint16 values are decoded in order
+	a, _, err := FindOptionData[[2]uint16](h, 0x0102, 0x01)
+	if err != nil {
+		t.Fatalf("failed to find option data: %v", err)
+	}
+	if want, got := [2]uint16{0xdead, 0xbeef}, a; want != got {
+		t.Fatalf("unexpected value:\n- want: %#x\n-  got: %#x", want, got)
+	}
+
+	if _, ok, err := FindOptionData[uint32](h, 0x0102, 0x02); ok || err != nil {
+		t.Fatalf("unexpected result for missing option: %v, %v", ok, err)
+	}
+
+	if _, ok, err := FindOptionData[uint64](h, 0x0102, 0x01); !ok || err != errOptionDataSize {
+		t.Fatalf("unexpected result for wrong size: %v, %v", ok, err)
+	}
+
+	if _, ok, err := FindOptionData[[]byte](h, 0x0102, 0x01); !ok || err != errOptionDataSize {
+		t.Fatalf("unexpected result for variable size: %v, %v", ok, err)
+	}
+}
+
+func TestHeaderClone(t *testing.T) {
+	h := &Header{
+		Version:      Version,
+		FlagCritical: true,
+		ProtocolType: ProtocolTypeIPv4,
+		VNI:          10,
+		Options: []*Option{{
+			OptionClass:  0x0102,
+			FlagCritical: true,
+			Type:         0x01,
+			Data:         []byte{0, 1, 2, 3},
+		}},
+	}
+
+	ch := h.Clone()
+	if want, got := h, ch; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected clone:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Modifying the clone must not affect the original
+	ch.Options[0].Data[0] = 0xff
+	ch.Options[0].Type = 0x02
+	ch.Options = append(ch.Options, &Option{})
+
+	if want, got := 1, len(h.Options); want != got {
+		t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := (&Option{
+		OptionClass:  0x0102,
+		FlagCritical: true,
+		Type:         0x01,
+		Data:         []byte{0, 1, 2, 3},
+	}), h.Options[0]; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Option:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderReply(t *testing.T) {
+	// The received Header has reserved bits set
+	b := []byte{
+		0x01, 0x15, 0x65, 0x58, 0x00, 0x00, 0x0a, 0x2a,
+		0x01, 0x02, 0x01, 0x00,
+	}
+
+	h := new(Header)
+	if err := h.Decode(b, &DecodeOptions{KeepRaw: true}); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+
+	rh := h.Reply()
+	if !rh.FlagOAM {
+		t.Fatal("expected reply to have FlagOAM set")
+	}
+	if h.FlagOAM {
+		t.Fatal("original Header must not be modified")
+	}
+	if rh.Raw() != nil {
+		t.Fatal("expected reply to have no raw form")
+	}
+	if rh.FlagsReserved != 0 || rh.Reserved != 0 {
+		t.Fatalf("expected reply to have no reserved fields: %#02x, %#02x",
+			rh.FlagsReserved, rh.Reserved)
+	}
+	if h.FlagsReserved != 0x15 || h.Reserved != 0x2a {
+		t.Fatal("original Header reserved fields must not be modified")
+	}
+
+	if want, got := h.VNI, rh.VNI; want != got {
+		t.Fatalf("unexpected VNI:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := h.Options, rh.Options; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderPartitionCritical(t *testing.T) {
+	var (
+		known   = &Option{OptionClass: 0x0001, FlagCritical: true}
+		unknown = &Option{OptionClass: 0x0002, FlagCritical: true}
+		nc      = &Option{OptionClass: 0x0003}
+	)
+
+	h := &Header{
+		Options: []*Option{nc, unknown, known},
+	}
+
+	supported, unsupported := h.PartitionCritical(map[uint16]bool{
+		0x0001: true,
+		0x0003: true,
+	})
+
+	if want, got := []*Option{known}, supported; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected supported Options:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := []*Option{unknown}, unsupported; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected unsupported Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// With no known classes, every critical Option is unsupported
+	supported, unsupported = h.PartitionCritical(nil)
+	if len(supported) != 0 || len(unsupported) != 2 {
+		t.Fatalf("unexpected partition: %v, %v", supported, unsupported)
+	}
+}
+
+func TestHeaderWireEqual(t *testing.T) {
+	tests := []struct {
+		desc  string
+		a, b  *Header
+		equal bool
+		err   error
+	}{
+		{
+			desc:  "nil and empty Options",
+			a:     &Header{VNI: 1},
+			b:     &Header{VNI: 1, Options: Options{}},
+			equal: true,
+		},
+		{
+			desc:  "nil and empty Option data",
+			a:     &Header{Options: []*Option{{OptionClass: 1}}},
+			b:     &Header{Options: []*Option{{OptionClass: 1, Data: []byte{}}}},
+			equal: true,
+		},
+		{
+			desc: "different VNI",
+			a:    &Header{VNI: 1},
+			b:    &Header{VNI: 2},
+		},
+		{
+			desc: "invalid Header",
+			a:    &Header{},
+			b:    &Header{VNI: MaxVNI + 1},
+			err:  errInvalidVNI,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		equal, err := tt.a.WireEqual(tt.b)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.equal, equal; want != got {
+			t.Fatalf("unexpected WireEqual result:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderMarshalBinaryUnchecked(t *testing.T) {
+	h := &Header{
+		Version:      Version,
+		FlagOAM:      true,
+		ProtocolType: ProtocolTypeIPv4,
+		VNI:          0x00bbeeff,
+		Options: []*Option{{
+			OptionClass: 0x0102,
+			Type:        0x01,
+			Data:        []byte{0, 1, 2, 3},
+		}},
+	}
+
+	want, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	got, err := h.MarshalBinaryUnchecked()
+	if err != nil {
+		t.Fatalf("failed to marshal unchecked Header: %v", err)
+	}
+
+	if !bytes.Equal(want, got) {
+		t.Fatalf("unexpected Header bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The VNI is not verified, but Options still are
+	h.VNI = MaxVNI + 1
+	if _, err := h.MarshalBinaryUnchecked(); err != nil {
+		t.Fatalf("unexpected error for unchecked VNI: %v", err)
+	}
+
+	h.Options[0].Data = []byte{0}
+	if _, err := h.MarshalBinaryUnchecked(); err != errInvalidOptionDataLength {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidOptionDataLength, err)
+	}
+}
+
+func TestHeaderRemainingOptionsBytes(t *testing.T) {
+	h := new(Header)
+	if want, got := MaxHeaderOptionsLen, h.RemainingOptionsBytes(); want != got {
+		t.Fatalf("unexpected remaining bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Fill the Header until no bytes remain, and verify it can be marshaled
+	for h.RemainingOptionsBytes() > 0 {
+		n := h.RemainingOptionsBytes() - optionHeaderLen
+		if n > maxOptionLength*4 {
+			n = maxOptionLength * 4
+		}
+
+		h.AddOption(&Option{Data: make([]byte, n)})
+	}
+
+	if want, got := 0, h.RemainingOptionsBytes(); want != got {
+		t.Fatalf("unexpected remaining bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+	if _, err := h.MarshalBinary(); err != nil {
+		t.Fatalf("failed to marshal full Header: %v", err)
+	}
+
+	h.AddOption(&Option{})
+	if want, got := -optionHeaderLen, h.RemainingOptionsBytes(); want != got {
+		t.Fatalf("unexpected remaining bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderMaxOptionClassRoundTrip(t *testing.T) {
+	h := &Header{
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00bbeeff,
+		Options: []*Option{
+			{
+				OptionClass: 0xffff,
+				Type:        0x01,
+				Data:        []byte{0, 1, 2, 3},
+			},
+			{
+				OptionClass: 0x0000,
+				Type:        0x02,
+			},
+		},
+	}
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	// The class bytes must not bleed into the critical and type byte
+	if want, got := []byte{0xff, 0xff, 0x01, 0x01}, b[headerLen:headerLen+optionHeaderLen]; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected Option header:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	h2 := new(Header)
+	if err := h2.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if want, got := 2, len(h2.Options); want != got {
+		t.Fatalf("unexpected number of Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	for i := range h.Options {
+		want, got := h.Options[i], h2.Options[i]
+		if want.OptionClass != got.OptionClass || want.FlagCritical != got.FlagCritical ||
+			want.Type != got.Type || !bytes.Equal(want.Data, got.Data) {
+			t.Fatalf("unexpected Option %d:\n- want: %v\n-  got: %v", i, want, got)
+		}
+	}
+}
+
+func TestHeaderOptionByteRange(t *testing.T) {
+	h := &Header{
+		Options: []*Option{
+			{OptionClass: 0x0001, Data: []byte{0, 1, 2, 3}},
+			{OptionClass: 0x0002},
+			{OptionClass: 0x0003, Data: []byte{4, 5, 6, 7, 8, 9, 10, 11}},
+		},
+	}
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	for i, o := range h.Options {
+		start, end, ok := h.OptionByteRange(i)
+		if !ok {
+			t.Fatalf("expected byte range for Option %d", i)
+		}
+
+		want, err := o.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal Option: %v", err)
+		}
+
+		if got := b[start:end]; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected Option %d bytes:\n- want: %v\n-  got: %v", i, want, got)
+		}
+	}
+
+	for _, i := range []int{-1, len(h.Options)} {
+		if _, _, ok := h.OptionByteRange(i); ok {
+			t.Fatalf("expected no byte range for index %d", i)
+		}
+	}
+}
+
+func TestHeaderMalformedOptionsErrors(t *testing.T) {
+	// Each cause of malformed Options is reported with a distinct error, so
+	// that callers can classify them
+	tests := []struct {
+		desc string
+		fn   func() error
+		err  error
+	}{
+		{
+			desc: "truncated options area",
+			fn: func() error {
+				return new(Header).UnmarshalBinary([]byte{
+					0x02, 0x00, 0x65, 0x58, 0x00, 0x00, 0x01, 0x00,
+					0x00, 0x01, 0x01, 0x01,
+				})
+			},
+			err: io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "options do not match declared length",
+			fn: func() error {
+				return new(Header).UnmarshalBinary([]byte{
+					0x01, 0x00, 0x65, 0x58, 0x00, 0x00, 0x01, 0x00,
+					0x00, 0x01, 0x01, 0x01,
+					0x00, 0x00, 0x00, 0x00,
+				})
+			},
+			err: errOptionsMisaligned,
+		},
+		{
+			desc: "invalid individual option length",
+			fn: func() error {
+				return new(Option).UnmarshalBinary([]byte{
+					0x00, 0x01, 0x01, 0x00,
+					0x00,
+				})
+			},
+			err: errInvalidOptionLength,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.err, tt.fn(); want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestMarshalFixedHeader(t *testing.T) {
+	h := &Header{
+		FlagOAM:      true,
+		ProtocolType: ProtocolTypeIPv6,
+		VNI:          0x00bbeeff,
+	}
+
+	want, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	got, err := MarshalFixedHeader(Version, true, false, ProtocolTypeIPv6, 0x00bbeeff)
+	if err != nil {
+		t.Fatalf("failed to marshal fixed header: %v", err)
+	}
+
+	if !bytes.Equal(want, got[:]) {
+		t.Fatalf("unexpected header bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if _, err := MarshalFixedHeader(Version+1, false, false, 0, 0); err != errInvalidVersion {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidVersion, err)
+	}
+	if _, err := MarshalFixedHeader(Version, false, false, 0, MaxVNI+1); err != errInvalidVNI {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidVNI, err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = MarshalFixedHeader(Version, false, false, ProtocolTypeEthernet, 1)
+	})
+	if allocs != 0 {
+		t.Fatalf("unexpected allocations: %v", allocs)
+	}
+}
+
+func Test_packByte0(t *testing.T) {
+	for v := uint8(0); v < 4; v++ {
+		for l := uint8(0); l < 64; l++ {
+			b := packByte0(v, l)
+			if want, got := v<<6|l, b; want != got {
+				t.Fatalf("unexpected byte for version %d, length %d:\n- want: %#02x\n-  got: %#02x",
+					v, l, want, got)
+			}
+
+			gv, gl := unpackByte0(b)
+			if gv != v || gl != l {
+				t.Fatalf("unexpected unpacked values for %#02x: %d, %d", b, gv, gl)
+			}
+		}
+	}
+
+	// An options length which does not fit in 6 bits must not overwrite
+	// the version
+	if want, got := byte(0x40|0x3f), packByte0(1, 0xff); want != got {
+		t.Fatalf("unexpected byte:\n- want: %#02x\n-  got: %#02x", want, got)
+	}
+}
+
+func Test_packByte1(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		b := byte(i)
+
+		oam, critical, reserved := unpackByte1(b)
+		if want, got := b&0x80 != 0, oam; want != got {
+			t.Fatalf("unexpected OAM flag for %#02x: %v", b, got)
+		}
+		if want, got := b&0x40 != 0, critical; want != got {
+			t.Fatalf("unexpected critical flag for %#02x: %v", b, got)
+		}
+		if want, got := b&0x3f, reserved; want != got {
+			t.Fatalf("unexpected reserved bits for %#02x: %#02x", b, got)
+		}
+
+		if want, got := b, packByte1(oam, critical, reserved); want != got {
+			t.Fatalf("unexpected packed byte:\n- want: %#02x\n-  got: %#02x", want, got)
+		}
+	}
+
+	// Reserved bits which do not fit in 6 bits must not overwrite the flags
+	if want, got := byte(0x3f), packByte1(false, false, 0xff); want != got {
+		t.Fatalf("unexpected byte:\n- want: %#02x\n-  got: %#02x", want, got)
+	}
+}
+
+func TestHeaderRemoveOptionReencode(t *testing.T) {
+	b := []byte{
+		// Header
+		0x05,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x01,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x01,
+		0x01,
+		0, 1, 2, 3,
+		// Option
+		0x00, 0x02,
+		0x01,
+		0x00,
+		// Option
+		0x00, 0x03,
+		0x01,
+		0x01,
+		4, 5, 6, 7,
+		// Payload
+		0xff, 0xff, 0xff, 0xff,
+	}
+
+	h := new(Header)
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	// Remove two Options, and verify a missing Option is not removed
+	for _, class := range []uint16{0x0001, 0x0002} {
+		if !h.RemoveOption(class, 0x01) {
+			t.Fatalf("failed to remove Option with class %#04x", class)
+		}
+	}
+	if h.RemoveOption(0x0001, 0x01) {
+		t.Fatal("unexpectedly removed missing Option")
+	}
+
+	rb, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	if want, got := headerLen+8, len(rb); want != got {
+		t.Fatalf("unexpected Header length:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	ol, err := DeclaredOptionsLen(rb)
+	if err != nil {
+		t.Fatalf("failed to read options length: %v", err)
+	}
+	if want, got := 8, ol; want != got {
+		t.Fatalf("unexpected options length:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	h2 := new(Header)
+	if err := h2.UnmarshalBinary(rb); err != nil {
+		t.Fatalf("failed to unmarshal reencoded Header: %v", err)
+	}
+
+	want := Options{{
+		OptionClass: 0x0003,
+		Type:        0x01,
+		Data:        []byte{4, 5, 6, 7},
+		DataLen:     4,
+	}}
+
+	if got := h2.Options; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderVNIAllowed(t *testing.T) {
+	allowed := map[VNI]bool{
+		10: true,
+		20: false,
+	}
+
+	tests := []struct {
+		vni             VNI
+		allowed, ranged bool
+	}{
+		{vni: 0},
+		{vni: 10, allowed: true, ranged: true},
+		{vni: 15, ranged: true},
+		{vni: 20, ranged: true},
+		{vni: 21},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test VNI %d", i, tt.vni)
+
+		h := &Header{VNI: tt.vni}
+		if want, got := tt.allowed, h.VNIAllowed(allowed); want != got {
+			t.Fatalf("unexpected VNIAllowed:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.ranged, h.VNIInRange(10, 20); want != got {
+			t.Fatalf("unexpected VNIInRange:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+// TestSentinelErrors verifies that every sentinel error in this package can
+// be produced by a minimal input, and matched using errors.Is.  Add a case
+// here when adding a new sentinel error.
+func TestSentinelErrors(t *testing.T) {
+	fixed := []byte{0x00, 0x00, 0x65, 0x58, 0x00, 0x00, 0x01, 0x00}
+
+	marshal := func(h *Header) error {
+		_, err := h.MarshalBinary()
+		return err
+	}
+
+	marshalOption := func(o *Option) error {
+		_, err := o.MarshalBinary()
+		return err
+	}
+
+	tests := []struct {
+		desc string
+		fn   func() error
+		err  error
+	}{
+		{
+			desc: "invalid version",
+			fn:   func() error { return marshal(&Header{Version: Version + 1}) },
+			err:  errInvalidVersion,
+		},
+		{
+			desc: "invalid VNI",
+			fn:   func() error { return marshal(&Header{VNI: MaxVNI + 1}) },
+			err:  errInvalidVNI,
+		},
+		{
+			desc: "invalid reserved flags",
+			fn:   func() error { return marshal(&Header{FlagsReserved: 0x40}) },
+			err:  errInvalidFlagsReserved,
+		},
+		{
+			desc: "options unaligned",
+			fn: func() error {
+				_, err := UnmarshalOptions([]byte{0x00, 0x00})
+				return err
+			},
+			err: errOptionsUnaligned,
+		},
+		{
+			desc: "options too long",
+			fn: func() error {
+				o := &Option{Data: make([]byte, maxOptionLength*4)}
+				return marshal(&Header{Options: Options{o, o}})
+			},
+			err: errOptionsTooLong,
+		},
+		{
+			desc: "impossible length",
+			fn: func() error {
+				_, err := optionsLenField(-4)
+				return err
+			},
+			err: errImpossibleLength,
+		},
+		{
+			desc: "options misaligned",
+			fn: func() error {
+				_, err := UnmarshalOptions([]byte{0x00, 0x00, 0x00, 0x01})
+				return err
+			},
+			err: errOptionsMisaligned,
+		},
+		{
+			desc: "invalid option data length",
+			fn:   func() error { return marshalOption(&Option{Data: []byte{0x00}}) },
+			err:  errInvalidOptionDataLength,
+		},
+		{
+			desc: "invalid option type",
+			fn:   func() error { return marshalOption(&Option{Type: maxOptionType + 1}) },
+			err:  errInvalidOptionType,
+		},
+		{
+			desc: "invalid option length",
+			fn: func() error {
+				return marshalOption(&Option{Data: make([]byte, (maxOptionLength+1)*4)})
+			},
+			err: errInvalidOptionLength,
+		},
+		{
+			desc: "option data size",
+			fn: func() error {
+				_, _, err := FindOptionData[uint64](&Header{Options: Options{{}}}, 0, 0)
+				return err
+			},
+			err: errOptionDataSize,
+		},
+		{
+			desc: "option data too long",
+			fn: func() error {
+				b := append([]byte{0x02}, fixed[1:]...)
+				b = append(b, 0x00, 0x01, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00)
+				return new(Header).Decode(b, &DecodeOptions{MaxOptionDataLen: 2})
+			},
+			err: errOptionDataTooLong,
+		},
+		{
+			desc: "reserved not zero",
+			fn: func() error {
+				b := append(fixed[:7:7], 0x01)
+				return new(Header).Decode(b, &DecodeOptions{Strict: true})
+			},
+			err: errReservedNotZero,
+		},
+		{
+			desc: "trailing bytes",
+			fn: func() error {
+				b := append(fixed[:8:8], 0xff)
+				return new(Header).Decode(b, &DecodeOptions{RequireExactLen: true})
+			},
+			err: errTrailingBytes,
+		},
+		{
+			desc: "payload too short",
+			fn: func() error {
+				_, _, err := DecapsulateMin(fixed, 1)
+				return err
+			},
+			err: errPayloadTooShort,
+		},
+		{
+			desc: "exceeds MTU",
+			fn:   func() error { return CheckMTU(fixed, headerLen-1) },
+			err:  errExceedsMTU,
+		},
+		{
+			desc: "too many packets",
+			fn: func() error {
+				_, err := ParsePacketsN(fixed, 0, func(_ *Header) int { return 0 })
+				return err
+			},
+			err: errTooManyPackets,
+		},
+		{
+			desc: "VNIs unaligned",
+			fn: func() error {
+				_, err := UnpackVNIs([]byte{0x00})
+				return err
+			},
+			err: errVNIsUnaligned,
+		},
+		{
+			desc: "invalid payload length",
+			fn: func() error {
+				_, err := ParsePackets(fixed, func(_ *Header) int { return -1 })
+				return err
+			},
+			err: errInvalidPayloadLength,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if err := tt.fn(); !errors.Is(err, tt.err) {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", tt.err, err)
+		}
+	}
+}
+
+func TestHeaderOptionClasses(t *testing.T) {
+	if got := new(Header).OptionClasses(); got != nil {
+		t.Fatalf("unexpected classes for no Options: %v", got)
+	}
+
+	h := &Header{
+		Options: []*Option{
+			{OptionClass: 0x0003},
+			{OptionClass: 0x0001},
+			{OptionClass: 0x0003, Type: 0x01},
+			{OptionClass: 0x0002},
+			{OptionClass: 0x0001, Type: 0x01},
+		},
+	}
+
+	if want, got := []uint16{0x0003, 0x0001, 0x0002}, h.OptionClasses(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected classes:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderMarshalOptionsAligned(t *testing.T) {
+	// Every valid combination of Options must produce an options area which
+	// is a multiple of 4 bytes, and is exactly described by the options
+	// length field
+	for _, lens := range [][]int{
+		nil,
+		{0},
+		{4},
+		{0, 0, 0},
+		{4, 8, 12},
+		{maxOptionLength * 4},
+		{maxOptionLength * 4, (maxOptionLength - 1) * 4},
+	} {
+		h := new(Header)
+		for _, n := range lens {
+			h.Options = append(h.Options, &Option{Data: make([]byte, n)})
+		}
+
+		obs, err := h.MarshalOptions()
+		if err != nil {
+			t.Fatalf("failed to marshal Options %v: %v", lens, err)
+		}
+		if len(obs)%4 != 0 {
+			t.Fatalf("unaligned options area for Options %v: %d bytes", lens, len(obs))
+		}
+
+		b, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal Header %v: %v", lens, err)
+		}
+
+		ol, err := DeclaredOptionsLen(b)
+		if err != nil {
+			t.Fatalf("failed to read options length: %v", err)
+		}
+
+		if want, got := len(obs), ol; want != got {
+			t.Fatalf("unexpected options length for Options %v:\n- want: %v\n-  got: %v", lens, want, got)
+		}
+	}
+
+	// An options area which could not be described by the field is rejected
+	// rather than truncated
+	if _, err := optionsLenField(6); err != errOptionsUnaligned {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errOptionsUnaligned, err)
+	}
+}
+
+func TestHeaderMatchOptionShape(t *testing.T) {
+	h := &Header{
+		Options: []*Option{
+			{OptionClass: 0x0001, Type: 0x01, Data: []byte{0, 1, 2, 3}},
+			{OptionClass: 0x0002, Type: 0x02, FlagCritical: true},
+		},
+	}
+
+	tests := []struct {
+		desc     string
+		expected []OptionShape
+		ok       bool
+	}{
+		{
+			desc: "match",
+			expected: []OptionShape{
+				{Class: 0x0001, Type: 0x01},
+				{Class: 0x0002, Type: 0x02, Critical: true},
+			},
+			ok: true,
+		},
+		{
+			desc: "too few",
+			expected: []OptionShape{
+				{Class: 0x0001, Type: 0x01},
+			},
+		},
+		{
+			desc: "wrong order",
+			expected: []OptionShape{
+				{Class: 0x0002, Type: 0x02, Critical: true},
+				{Class: 0x0001, Type: 0x01},
+			},
+		},
+		{
+			desc: "not critical",
+			expected: []OptionShape{
+				{Class: 0x0001, Type: 0x01},
+				{Class: 0x0002, Type: 0x02},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.ok, h.MatchOptionShape(tt.expected); want != got {
+			t.Fatalf("unexpected MatchOptionShape:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.ok, h.OptionShapeMismatch(tt.expected) == nil; want != got {
+			t.Fatalf("unexpected OptionShapeMismatch:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderOptionsCanonical(t *testing.T) {
+	h := &Header{
+		Options: []*Option{
+			{OptionClass: 0x0002, Type: 0x01},
+			{OptionClass: 0x0001, Type: 0x02},
+			{OptionClass: 0x0001, Type: 0x01},
+		},
+	}
+
+	if h.OptionsCanonical() {
+		t.Fatal("expected Options not to be canonical")
+	}
+
+	h.Options.Sort()
+	if !h.OptionsCanonical() {
+		t.Fatal("expected sorted Options to be canonical")
+	}
+
+	if !new(Header).OptionsCanonical() {
+		t.Fatal("expected no Options to be canonical")
+	}
+}
+
+func TestHeaderCriticalMaxOptionTypeRoundTrip(t *testing.T) {
+	h := &Header{
+		FlagCritical: true,
+		Options: []*Option{{
+			OptionClass:  0x0001,
+			FlagCritical: true,
+			Type:         maxOptionType,
+			Data:         []byte{0, 1, 2, 3},
+			DataLen:      4,
+		}},
+	}
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	// The critical bit and the maximum Type share a single byte
+	if want, got := byte(0xff), b[headerLen+2]; want != got {
+		t.Fatalf("unexpected critical and type byte:\n- want: %#02x\n-  got: %#02x", want, got)
+	}
+
+	h2 := new(Header)
+	if err := h2.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if want, got := h, h2; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestMarshalHeaderStream(t *testing.T) {
+	opts := []*Option{
+		{OptionClass: 0x0001, Type: 0x01, Data: []byte{0, 1, 2, 3}},
+		{OptionClass: 0x0002, FlagCritical: true, Type: 0x02},
+	}
+
+	emitAll := func(opts []*Option) func(add func(o *Option) error) error {
+		return func(add func(o *Option) error) error {
+			for _, o := range opts {
+				if err := add(o); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	h := &Header{
+		FlagCritical: true,
+		ProtocolType: ProtocolTypeIPv4,
+		VNI:          10,
+		Options:      opts,
+	}
+
+	want, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	got, err := MarshalHeaderStream(Version, false, true, ProtocolTypeIPv4, 10, emitAll(opts))
+	if err != nil {
+		t.Fatalf("failed to marshal Header stream: %v", err)
+	}
+
+	if !bytes.Equal(want, got) {
+		t.Fatalf("unexpected Header bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Each error is reported by add or by MarshalHeaderStream itself
+	long := &Option{Data: make([]byte, maxOptionLength*4)}
+	tests := []struct {
+		desc string
+		vni  VNI
+		opts []*Option
+		err  error
+	}{
+		{
+			desc: "invalid VNI",
+			vni:  MaxVNI + 1,
+			err:  errInvalidVNI,
+		},
+		{
+			desc: "invalid Option",
+			opts: []*Option{{Data: []byte{0}}},
+			err:  errInvalidOptionDataLength,
+		},
+		{
+			desc: "Options too long",
+			opts: []*Option{long, long},
+			err:  errOptionsTooLong,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		_, err := MarshalHeaderStream(Version, false, false, 0, tt.vni, emitAll(tt.opts))
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderKey(t *testing.T) {
+	h := &Header{
+		FlagOAM:      true,
+		ProtocolType: ProtocolTypeIPv6,
+		VNI:          0x00bbeeff,
+		Options:      []*Option{{OptionClass: 0x0001}},
+	}
+
+	if want, got := [5]byte{0xbb, 0xee, 0xff, 0x86, 0xdd}, h.Key(); want != got {
+		t.Fatalf("unexpected key:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Flags and Options do not affect the key
+	flows := map[[5]byte]int{h.Key(): 1}
+	if want, got := 1, flows[(&Header{ProtocolType: ProtocolTypeIPv6, VNI: 0x00bbeeff}).Key()]; want != got {
+		t.Fatalf("unexpected flow table lookup:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestHeaderReserved(t *testing.T) {
+	b := []byte{
+		0x00,
+		0x95,
+		0x65, 0x58,
+		0xbb, 0xee, 0xff,
+		0x2a,
+	}
+
+	h := new(Header)
+	if err := h.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Header: %v", err)
+	}
+
+	if want, got := uint8(0x15), h.FlagsReserved; want != got {
+		t.Fatalf("unexpected reserved flags:\n- want: %#02x\n-  got: %#02x", want, got)
+	}
+	if want, got := uint8(0x2a), h.Reserved; want != got {
+		t.Fatalf("unexpected reserved byte:\n- want: %#02x\n-  got: %#02x", want, got)
+	}
+
+	// Reserved fields are preserved when a Header is rewritten
+	rb, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+	if want, got := b, rb; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected Header bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The canonical form never contains reserved fields
+	cb, err := h.Canonical()
+	if err != nil {
+		t.Fatalf("failed to canonicalize Header: %v", err)
+	}
+	if want, got := []byte{0x00, 0x80, 0x65, 0x58, 0xbb, 0xee, 0xff, 0x00}, cb; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected canonical bytes:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	nh := &Header{VNI: 1}
+	nh.CopyReservedFrom(h)
+	if nh.FlagsReserved != h.FlagsReserved || nh.Reserved != h.Reserved {
+		t.Fatalf("reserved fields not copied: %#02x, %#02x", nh.FlagsReserved, nh.Reserved)
+	}
+
+	h.ClearReserved()
+	if h.FlagsReserved != 0 || h.Reserved != 0 {
+		t.Fatalf("reserved fields not cleared: %#02x, %#02x", h.FlagsReserved, h.Reserved)
+	}
+}
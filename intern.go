@@ -0,0 +1,59 @@
+package geneve
+
+import "sync"
+
+// An Interner deduplicates identical byte slices, so that many Options with
+// the same Data can share a single read-only copy of that Data.  This reduces
+// memory usage when many decoded Headers are retained, such as in a flow
+// table keyed by Header.  Use an Interner by setting DecodeOptions.Intern.
+//
+// Interned byte slices are shared by every Option with the same Data, and
+// must never be modified.  To modify the Data of an Option decoded with an
+// Interner, replace its Data with a new byte slice instead.
+//
+// An Interner retains every distinct byte slice it is given for its entire
+// lifetime.  The zero value of Interner is ready to use, and an Interner is
+// safe for concurrent use.
+type Interner struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+// Intern returns a read-only byte slice with the same contents as b.  If an
+// identical byte slice was previously interned, it is returned; otherwise, a
+// copy of b is retained and returned.  If b is empty, nil is returned.  The
+// returned slice never aliases b.
+func (in *Interner) Intern(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	// The string conversion in a map index does not allocate
+	if ib, ok := in.m[string(b)]; ok {
+		return ib
+	}
+
+	if in.m == nil {
+		in.m = make(map[string][]byte)
+	}
+
+	// Cap the interned slice so that appending to it cannot modify the
+	// shared copy
+	ib := make([]byte, len(b))
+	copy(ib, b)
+	ib = ib[:len(ib):len(ib)]
+
+	in.m[string(ib)] = ib
+	return ib
+}
+
+// Len returns the number of distinct byte slices retained by an Interner.
+func (in *Interner) Len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return len(in.m)
+}
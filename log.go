@@ -0,0 +1,43 @@
+package geneve
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+var (
+	_ slog.LogValuer = &Header{}
+	_ slog.LogValuer = &Option{}
+)
+
+// LogValue implements slog.LogValuer, and produces a group containing a
+// Header's fields and a nested group of its Options, keyed by index.  The
+// Header is not marshaled, so LogValue is inexpensive.
+func (h *Header) LogValue() slog.Value {
+	opts := make([]slog.Attr, 0, len(h.Options))
+	for i, o := range h.Options {
+		opts = append(opts, slog.Any(strconv.Itoa(i), o))
+	}
+
+	return slog.GroupValue(
+		slog.Int("version", int(h.Version)),
+		slog.Bool("oam", h.FlagOAM),
+		slog.Bool("critical", h.FlagCritical),
+		slog.String("protocol_type", h.ProtocolType.String()),
+		slog.String("vni", fmt.Sprintf("0x%06x", uint32(h.VNI))),
+		slog.Int("options_count", len(h.Options)),
+		slog.Attr{Key: "options", Value: slog.GroupValue(opts...)},
+	)
+}
+
+// LogValue implements slog.LogValuer, and produces a group containing an
+// Option's class, type, critical flag, and data length.
+func (o *Option) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("class", fmt.Sprintf("0x%04x", o.OptionClass)),
+		slog.Int("type", int(o.Type)),
+		slog.Bool("critical", o.FlagCritical),
+		slog.Int("data_len", len(o.Data)),
+	)
+}
@@ -0,0 +1,68 @@
+package geneve
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestHeaderLogValue(t *testing.T) {
+	h := &Header{
+		FlagCritical: true,
+		ProtocolType: ProtocolTypeEthernet,
+		VNI:          0x00bbeeff,
+		Options: []*Option{
+			{OptionClass: 0x0001, FlagCritical: true},
+			{OptionClass: 0x0002, Type: 0x01, Data: []byte{0, 1, 2, 3}},
+		},
+	}
+
+	want := "level=INFO msg=test header.version=0 header.oam=false header.critical=true" +
+		" header.protocol_type=ethernet header.vni=0xbbeeff header.options_count=2" +
+		" header.options.0.class=0x0001 header.options.0.type=0" +
+		" header.options.0.critical=true header.options.0.data_len=0" +
+		" header.options.1.class=0x0002 header.options.1.type=1" +
+		" header.options.1.critical=false header.options.1.data_len=4\n"
+	if got := logString(slog.Any("header", h)); want != got {
+		t.Fatalf("unexpected log output:\n- want: %q\n-  got: %q", want, got)
+	}
+
+	// Options group is omitted when empty
+	want = "level=INFO msg=test header.version=0 header.oam=false header.critical=false" +
+		" header.protocol_type=0x0001 header.vni=0x000001 header.options_count=0\n"
+	if got := logString(slog.Any("header", &Header{ProtocolType: 1, VNI: 1})); want != got {
+		t.Fatalf("unexpected log output:\n- want: %q\n-  got: %q", want, got)
+	}
+}
+
+func TestOptionLogValue(t *testing.T) {
+	o := &Option{
+		OptionClass:  0x0102,
+		FlagCritical: true,
+		Type:         0x03,
+		Data:         []byte{0, 1, 2, 3},
+	}
+
+	want := "level=INFO msg=test option.class=0x0102 option.type=3 option.critical=true option.data_len=4\n"
+	if got := logString(slog.Any("option", o)); want != got {
+		t.Fatalf("unexpected log output:\n- want: %q\n-  got: %q", want, got)
+	}
+}
+
+// logString logs a message with attr using a text handler, and returns the
+// output without its timestamp.
+func logString(attr slog.Attr) string {
+	var buf bytes.Buffer
+	ll := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+
+			return a
+		},
+	}))
+
+	ll.Info("test", attr)
+	return buf.String()
+}
@@ -1,9 +1,13 @@
 package geneve
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"sort"
 )
 
 const (
@@ -29,6 +33,10 @@ var (
 
 	// errInvalidOptionLength indicates that an option's length is too large.
 	errInvalidOptionLength = errors.New("invalid option length")
+
+	// errOptionDataSize indicates that an option's data cannot be decoded
+	// into a value because their sizes do not match.
+	errOptionDataSize = errors.New("option data length does not match size of value")
 )
 
 // An Option is a Geneve option, as described in the Geneve internet draft,
@@ -45,40 +53,106 @@ type Option struct {
 
 	// Data is arbitrary data whose format is specified by OptionClass and Type.
 	Data []byte
+
+	// DataLen specifies the number of significant bytes at the beginning of
+	// Data, when the remainder of Data is padding.  DataLen is not
+	// transmitted, so when an Option is decoded, DataLen is set to the length
+	// of Data; only an application which knows the significant length of the
+	// Data for an OptionClass and Type can distinguish data from padding.
+	DataLen int
 }
 
 // MarshalBinary allocates a byte slice and marshals an Option into binary form.
 func (o *Option) MarshalBinary() ([]byte, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, optionHeaderLen+len(o.Data))
+	o.put(b)
+
+	return b, nil
+}
+
+// SetPaddedData sets the Data of an Option to data, which must include any
+// padding required to make its length a multiple of 4, and sets DataLen to
+// realLen, the number of significant bytes at the beginning of data.  A
+// realLen of zero indicates that all of data is padding.  data is not copied.
+// If the length of data is not a multiple of 4, or realLen is not within the
+// bounds of data, an error is returned and the Option is not modified.
+func (o *Option) SetPaddedData(data []byte, realLen int) error {
+	if len(data)%4 != 0 {
+		return errInvalidOptionDataLength
+	}
+	if realLen < 0 || realLen > len(data) {
+		return errInvalidOptionLength
+	}
+
+	o.Data = data
+	o.DataLen = realLen
+	return nil
+}
+
+// SignificantData returns the significant bytes of an Option's Data, as
+// specified by DataLen.  If DataLen is out of range, all of Data is returned.
+func (o *Option) SignificantData() []byte {
+	if o.DataLen < 0 || o.DataLen > len(o.Data) {
+		return o.Data
+	}
+
+	return o.Data[:o.DataLen]
+}
+
+// DataLenIs reports whether an Option's Data is exactly n bytes long, such
+// as when an Option's Type implies a fixed size.
+func (o *Option) DataLenIs(n int) bool {
+	return len(o.Data) == n
+}
+
+// DataEqualConstantTime reports whether an Option's Data is equal to b, in
+// an amount of time which depends only on their lengths and not on their
+// contents.  This is useful for comparing security-sensitive Data, such as
+// an authentication tag, without leaking timing information.
+func (o *Option) DataEqualConstantTime(b []byte) bool {
+	return subtle.ConstantTimeCompare(o.Data, b) == 1
+}
+
+// validate verifies that an Option can be marshaled into binary form.
+func (o *Option) validate() error {
 	// Length of data must be divisible by 4
 	if len(o.Data)%4 != 0 {
-		return nil, errInvalidOptionDataLength
+		return errInvalidOptionDataLength
 	}
 
-	// Data length is encoded into byte slice by dividing original length by 4
-	ld := len(o.Data) / 4
-
 	// Type and data length must not be greater than protocol limits
 	if o.Type > maxOptionType {
-		return nil, errInvalidOptionType
+		return errInvalidOptionType
 	}
-	if ld > maxOptionLength {
-		return nil, errInvalidOptionLength
+	if len(o.Data)/4 > maxOptionLength {
+		return errInvalidOptionLength
 	}
 
-	b := make([]byte, optionHeaderLen+len(o.Data))
+	return nil
+}
 
+// put marshals an Option into b, and returns the number of bytes written.
+// The Option must be verified using validate, and b must be long enough to
+// contain it.
+func (o *Option) put(b []byte) int {
 	binary.BigEndian.PutUint16(b[0:2], o.OptionClass)
 
+	b[2] = o.Type
 	if o.FlagCritical {
 		b[2] |= (1 << 7)
 	}
 
-	b[2] |= o.Type
-	b[3] |= byte(ld)
+	// Data length is encoded into byte slice by dividing original length by 4;
+	// the length field is always derived from Data, so the two cannot disagree
+	b[3] = byte(len(o.Data) / 4)
 
 	copy(b[optionHeaderLen:], o.Data)
 
-	return b, nil
+	return optionHeaderLen + len(o.Data)
 }
 
 // UnmarshalBinary unmarshals a byte slice into an Option.
@@ -93,20 +167,332 @@ func (o *Option) UnmarshalBinary(b []byte) error {
 		return errInvalidOptionLength
 	}
 
+	n, err := o.unmarshalNoCopy(b)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, n-optionHeaderLen)
+	copy(data, o.Data)
+	o.Data = data
+
+	return nil
+}
+
+// unmarshalNoCopy unmarshals a byte slice into an Option, and returns the
+// number of bytes consumed by the Option.  o.Data points into b rather than
+// being copied.
+func (o *Option) unmarshalNoCopy(b []byte) (int, error) {
+	// Must contain enough data to produce an Option header
+	if len(b) < optionHeaderLen {
+		return 0, io.ErrUnexpectedEOF
+	}
+
 	// Low 5 bits, multiplied by 4, produce data length;
 	// input byte slice must be at least as long as option header plus
 	// specified data length
 	ol := int(b[3]&0x1f) * 4
 	if len(b) < optionHeaderLen+ol {
-		return io.ErrUnexpectedEOF
+		return 0, io.ErrUnexpectedEOF
 	}
 
 	o.OptionClass = binary.BigEndian.Uint16(b[0:2])
 	o.FlagCritical = (b[2] >> 7) == 1
 	o.Type = b[2] & 0x7f
+	o.Data = b[optionHeaderLen : optionHeaderLen+ol]
+
+	// Padding cannot be distinguished from data on the wire
+	o.DataLen = ol
+
+	return optionHeaderLen + ol, nil
+}
+
+// parseOptions parses an options area, which must contain only Options, and
+// invokes fn for each Option.  The Options must exactly consume b.  The Option
+// passed to fn is reused between calls, and its Data field points into b.
+func parseOptions(b []byte, fn func(o *Option) error) error {
+	var o Option
+	for i := 0; i < len(b); {
+		n, err := o.unmarshalNoCopy(b[i:])
+		if err != nil {
+			// The options area is already known to be present, so
+			// running out of bytes means an Option extends past the
+			// end of the area, rather than a truncated input
+			if err == io.ErrUnexpectedEOF {
+				return errOptionsMisaligned
+			}
 
-	o.Data = make([]byte, ol)
-	copy(o.Data, b[optionHeaderLen:optionHeaderLen+ol])
+			return err
+		}
+
+		if err := fn(&o); err != nil {
+			return err
+		}
+
+		// Each option is offset by length of its header and data
+		i += n
+	}
 
 	return nil
 }
+
+// checkOptions verifies that an options area is exactly consumed by its
+// Options, as parseOptions does, but without parsing the Options.  The
+// number of Options is returned.
+func checkOptions(b []byte) (int, error) {
+	var n int
+	for i := 0; i < len(b); n++ {
+		if len(b)-i < optionHeaderLen {
+			return 0, errOptionsMisaligned
+		}
+
+		// Each option is offset by length of its header and data
+		i += optionHeaderLen + int(b[i+3]&0x1f)*4
+		if i > len(b) {
+			return 0, errOptionsMisaligned
+		}
+	}
+
+	return n, nil
+}
+
+// copyOption returns a copy of an Option which does not share its Data.
+func copyOption(o *Option) *Option {
+	oc := *o
+	oc.Data = make([]byte, len(o.Data))
+	copy(oc.Data, o.Data)
+
+	return &oc
+}
+
+// UnmarshalOptions unmarshals a byte slice containing only an options area,
+// with no fixed header or payload, into Options.  The Options must exactly
+// consume b, which must be a multiple of 4 bytes in length.
+func UnmarshalOptions(b []byte) (Options, error) {
+	// Length of options area must be divisible by 4
+	if len(b)%4 != 0 {
+		return nil, errOptionsUnaligned
+	}
+
+	var opts Options
+	err := parseOptions(b, func(o *Option) error {
+		opts = append(opts, copyOption(o))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// UnmarshalOptionsN unmarshals exactly n bytes of Options from the beginning
+// of b, which may contain trailing bytes such as a payload.  This is useful
+// when the options length has already been read from a fixed header.  The
+// Options must exactly consume n bytes, which must be a multiple of 4 and no
+// greater than MaxHeaderOptionsLen.  If b is shorter than n bytes,
+// io.ErrUnexpectedEOF is returned.
+func UnmarshalOptionsN(b []byte, n int) (Options, error) {
+	if n < 0 || n > MaxHeaderOptionsLen {
+		return nil, errOptionsTooLong
+	}
+
+	if len(b) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return UnmarshalOptions(b[:n])
+}
+
+// Options is a slice of Geneve options, as carried by a Header.
+type Options []*Option
+
+// IsSorted reports whether Options are in canonical order: non-decreasing
+// by OptionClass, and then by Type for Options with the same OptionClass.
+func (o Options) IsSorted() bool {
+	for i := 1; i < len(o); i++ {
+		if optionLess(o[i], o[i-1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Sort sorts Options in place into canonical order, as described by
+// IsSorted.  The relative order of Options with the same OptionClass and
+// Type is preserved.
+func (o Options) Sort() {
+	sort.SliceStable(o, func(i, j int) bool {
+		return optionLess(o[i], o[j])
+	})
+}
+
+// optionLess reports whether Option a sorts before Option b in canonical
+// order.
+func optionLess(a, b *Option) bool {
+	if a.OptionClass != b.OptionClass {
+		return a.OptionClass < b.OptionClass
+	}
+
+	return a.Type < b.Type
+}
+
+// OptionsDiff compares Options a and b, matching Options by OptionClass and
+// Type.  added contains Options present only in b, and removed contains
+// Options present only in a.  changed contains the Options from b whose match
+// in a has different Data or a different critical flag.
+//
+// If multiple Options share the same OptionClass and Type, they are matched
+// with each other in order.
+func OptionsDiff(a, b Options) (added, removed, changed []*Option) {
+	matched := make([]bool, len(a))
+	for _, ob := range b {
+		j := -1
+		for i, oa := range a {
+			if !matched[i] && oa.OptionClass == ob.OptionClass && oa.Type == ob.Type {
+				j = i
+				break
+			}
+		}
+
+		if j == -1 {
+			added = append(added, ob)
+			continue
+		}
+
+		matched[j] = true
+		oa := a[j]
+		if oa.FlagCritical != ob.FlagCritical || !bytes.Equal(oa.Data, ob.Data) {
+			changed = append(changed, ob)
+		}
+	}
+
+	for i, oa := range a {
+		if !matched[i] {
+			removed = append(removed, oa)
+		}
+	}
+
+	return added, removed, changed
+}
+
+// OptionsLength returns the total length in bytes of opts when marshaled into
+// binary form.  The Options are not validated; the result may be compared with
+// MaxHeaderOptionsLen to determine if the Options will fit in a Header.
+func OptionsLength(opts []*Option) int {
+	var n int
+	for _, o := range opts {
+		n += optionHeaderLen + len(o.Data)
+	}
+
+	return n
+}
+
+// OptionsFitHeader verifies that opts, when marshaled into binary form, will
+// fit in the options area described by a Header's options length field.  This
+// is useful for checking an options area as it is assembled, before a Header is
+// constructed.  Only the total length is checked; use Header.MarshalBinary to
+// verify each Option.
+func OptionsFitHeader(opts []*Option) error {
+	n := OptionsLength(opts)
+
+	// Each Option should always be a multiple of 4 bytes, but Data is not
+	// validated, so verify the total as well
+	if n%4 != 0 {
+		return errOptionsUnaligned
+	}
+
+	if n > MaxHeaderOptionsLen {
+		return errOptionsTooLong
+	}
+
+	return nil
+}
+
+// A TLV describes an Option to be constructed by OptionsFromTLV.
+type TLV struct {
+	// Class, Type, and Critical specify the OptionClass, Type, and
+	// FlagCritical fields of the Option.
+	Class    uint16
+	Type     uint8
+	Critical bool
+
+	// Data specifies the Option's data.  It need not be a multiple of 4
+	// bytes in length: it is padded with zeros as needed.
+	Data []byte
+}
+
+// OptionsFromTLV constructs Options from tlvs in a single pass, padding the
+// data of each Option to a multiple of 4 bytes.  Each Option is verified to
+// be valid for marshaling; if any are not, an error joining the errors for
+// every invalid Option is returned.
+func OptionsFromTLV(tlvs []TLV) (Options, error) {
+	opts := make(Options, 0, len(tlvs))
+	var errs []error
+	for i, tlv := range tlvs {
+		// Round data length up to the next multiple of 4
+		data := make([]byte, (len(tlv.Data)+3)&^3)
+		copy(data, tlv.Data)
+
+		o := &Option{
+			OptionClass:  tlv.Class,
+			FlagCritical: tlv.Critical,
+			Type:         tlv.Type,
+			Data:         data,
+		}
+
+		if err := o.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("option %d: %w", i, err))
+			continue
+		}
+
+		opts = append(opts, o)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return opts, nil
+}
+
+// An OptionKey identifies an Option by its OptionClass and Type.
+type OptionKey struct {
+	Class uint16
+	Type  uint8
+}
+
+// An OptionShape describes the structure of an Option, ignoring its Data.
+type OptionShape struct {
+	Class    uint16
+	Type     uint8
+	Critical bool
+}
+
+// OptionsFromMap constructs non-critical Options from a map of OptionKey to
+// Option data, as with OptionsFromTLV.  Map iteration order is random, so the
+// Options are always returned in canonical order, as described by
+// Options.IsSorted: the same map always produces identical Options, and a
+// Header containing them always marshals to identical bytes.
+func OptionsFromMap(m map[OptionKey][]byte) (Options, error) {
+	tlvs := make([]TLV, 0, len(m))
+	for k, data := range m {
+		tlvs = append(tlvs, TLV{
+			Class: k.Class,
+			Type:  k.Type,
+			Data:  data,
+		})
+	}
+
+	// Keys are unique, so sorting by OptionClass and Type is a total order
+	sort.Slice(tlvs, func(i, j int) bool {
+		if tlvs[i].Class != tlvs[j].Class {
+			return tlvs[i].Class < tlvs[j].Class
+		}
+
+		return tlvs[i].Type < tlvs[j].Type
+	})
+
+	return OptionsFromTLV(tlvs)
+}
@@ -2,6 +2,7 @@ package geneve
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"reflect"
 	"testing"
@@ -123,14 +124,16 @@ func TestOptionUnmarshalBinary(t *testing.T) {
 			desc: "4 byte option data length",
 			b:    []byte{0, 0, 0, 0x01, 1, 2, 3, 4},
 			o: &Option{
-				Data: []byte{1, 2, 3, 4},
+				Data:    []byte{1, 2, 3, 4},
+				DataLen: 4,
 			},
 		},
 		{
 			desc: "4 byte option data length (ignoring reserved high bits in length byte)",
 			b:    []byte{0, 0, 0, 0xe1, 1, 2, 3, 4},
 			o: &Option{
-				Data: []byte{1, 2, 3, 4},
+				Data:    []byte{1, 2, 3, 4},
+				DataLen: 4,
 			},
 		},
 		{
@@ -142,7 +145,8 @@ func TestOptionUnmarshalBinary(t *testing.T) {
 			desc: "empty OK",
 			b:    []byte{0, 0, 0, 0},
 			o: &Option{
-				Data: make([]byte, 0),
+				Data:    make([]byte, 0),
+				DataLen: 0,
 			},
 		},
 		{
@@ -151,6 +155,7 @@ func TestOptionUnmarshalBinary(t *testing.T) {
 			o: &Option{
 				OptionClass: 0xffff,
 				Data:        make([]byte, 0),
+				DataLen:     0,
 			},
 		},
 		{
@@ -159,21 +164,24 @@ func TestOptionUnmarshalBinary(t *testing.T) {
 			o: &Option{
 				FlagCritical: true,
 				Data:         make([]byte, 0),
+				DataLen:      0,
 			},
 		},
 		{
 			desc: "type OK",
 			b:    []byte{0, 0, 0x7f, 0},
 			o: &Option{
-				Type: maxOptionType,
-				Data: make([]byte, 0),
+				Type:    maxOptionType,
+				Data:    make([]byte, 0),
+				DataLen: 0,
 			},
 		},
 		{
 			desc: "length OK",
 			b:    append([]byte{0, 0, 0, 0x1f}, make([]byte, 124)...),
 			o: &Option{
-				Data: make([]byte, maxOptionLength*4),
+				Data:    make([]byte, maxOptionLength*4),
+				DataLen: maxOptionLength * 4,
 			},
 		},
 		{
@@ -189,6 +197,7 @@ func TestOptionUnmarshalBinary(t *testing.T) {
 				FlagCritical: true,
 				Type:         0x02,
 				Data:         []byte{0, 1, 2, 3},
+				DataLen:      4,
 			},
 		},
 	}
@@ -210,3 +219,548 @@ func TestOptionUnmarshalBinary(t *testing.T) {
 		}
 	}
 }
+
+func TestOptionsIsSorted(t *testing.T) {
+	tests := []struct {
+		desc string
+		o    Options
+		ok   bool
+	}{
+		{
+			desc: "empty",
+			ok:   true,
+		},
+		{
+			desc: "sorted",
+			o: Options{
+				{OptionClass: 0x0001, Type: 0x02},
+				{OptionClass: 0x0001, Type: 0x03},
+				{OptionClass: 0x0002, Type: 0x01},
+			},
+			ok: true,
+		},
+		{
+			desc: "equal class and type",
+			o: Options{
+				{OptionClass: 0x0001, Type: 0x02, Data: []byte{1, 1, 1, 1}},
+				{OptionClass: 0x0001, Type: 0x02},
+			},
+			ok: true,
+		},
+		{
+			desc: "reverse class",
+			o: Options{
+				{OptionClass: 0x0002},
+				{OptionClass: 0x0001},
+			},
+		},
+		{
+			desc: "equal class, reverse type",
+			o: Options{
+				{OptionClass: 0x0001, Type: 0x03},
+				{OptionClass: 0x0001, Type: 0x02},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.ok, tt.o.IsSorted(); want != got {
+			t.Fatalf("unexpected IsSorted:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestUnmarshalOptions(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		o    Options
+		err  error
+	}{
+		{
+			desc: "input bytes length is not divisible by 4",
+			b:    []byte{0, 0, 0, 0, 1, 2},
+			err:  errOptionsUnaligned,
+		},
+		{
+			desc: "option extends past end of input",
+			b:    []byte{0, 0, 0, 0x02, 0, 0, 0, 0},
+			err:  errOptionsMisaligned,
+		},
+		{
+			desc: "empty OK",
+		},
+		{
+			desc: "two options OK",
+			b: []byte{
+				// Option
+				0x00, 0x01,
+				0x82,
+				0x01,
+				0, 1, 2, 3,
+				// Option
+				0x00, 0x02,
+				0x04,
+				0x00,
+			},
+			o: Options{
+				{
+					OptionClass:  0x0001,
+					FlagCritical: true,
+					Type:         0x02,
+					Data:         []byte{0, 1, 2, 3},
+					DataLen:      4,
+				},
+				{
+					OptionClass: 0x0002,
+					Type:        0x04,
+					Data:        []byte{},
+				},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		o, err := UnmarshalOptions(tt.b)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.o, o; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestOptionsDiff(t *testing.T) {
+	var (
+		unchanged = &Option{OptionClass: 0x0001, Type: 0x01, Data: []byte{0, 1, 2, 3}}
+		removed   = &Option{OptionClass: 0x0002, Type: 0x01}
+		before    = &Option{OptionClass: 0x0003, Type: 0x01, Data: []byte{0, 1, 2, 3}}
+		after     = &Option{OptionClass: 0x0003, Type: 0x01, Data: []byte{4, 5, 6, 7}}
+		critical  = &Option{OptionClass: 0x0004, Type: 0x01}
+		critical2 = &Option{OptionClass: 0x0004, Type: 0x01, FlagCritical: true}
+		added     = &Option{OptionClass: 0x0003, Type: 0x02}
+	)
+
+	tests := []struct {
+		desc                    string
+		a, b                    Options
+		added, removed, changed []*Option
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc: "identical",
+			a:    Options{unchanged},
+			b:    Options{unchanged},
+		},
+		{
+			desc:    "added, removed, and changed",
+			a:       Options{unchanged, removed, before, critical},
+			b:       Options{critical2, added, after, unchanged},
+			added:   []*Option{added},
+			removed: []*Option{removed},
+			changed: []*Option{critical2, after},
+		},
+		{
+			desc:  "duplicate class and type matched in order",
+			a:     Options{before},
+			b:     Options{before, after},
+			added: []*Option{after},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		added, removed, changed := OptionsDiff(tt.a, tt.b)
+
+		if want, got := tt.added, added; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected added:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.removed, removed; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected removed:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.changed, changed; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected changed:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestOptionMarshalBinaryLengthField(t *testing.T) {
+	for _, n := range []int{0, 4, 8, 12, 64, 120, maxOptionLength * 4} {
+		o := &Option{
+			Data: make([]byte, n),
+		}
+
+		b, err := o.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal Option with %d bytes of data: %v", n, err)
+		}
+
+		if want, got := byte(n/4), b[3]&0x1f; want != got {
+			t.Fatalf("unexpected length field for %d bytes of data:\n- want: %v\n-  got: %v",
+				n, want, got)
+		}
+
+		if want, got := optionHeaderLen+n, len(b); want != got {
+			t.Fatalf("unexpected Option length for %d bytes of data:\n- want: %v\n-  got: %v",
+				n, want, got)
+		}
+	}
+}
+
+func TestOptionsLength(t *testing.T) {
+	opts := []*Option{
+		{},
+		{Data: make([]byte, 4)},
+		{Data: make([]byte, maxOptionLength*4)},
+	}
+
+	if want, got := 0, OptionsLength(nil); want != got {
+		t.Fatalf("unexpected length for no Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	obs, err := (&Header{Options: opts}).MarshalOptions()
+	if err != nil {
+		t.Fatalf("failed to marshal Options: %v", err)
+	}
+
+	if want, got := len(obs), OptionsLength(opts); want != got {
+		t.Fatalf("unexpected length:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestOptionDataLenIs(t *testing.T) {
+	o := &Option{Data: make([]byte, 8)}
+
+	if !o.DataLenIs(8) {
+		t.Fatal("expected Option data length of 8")
+	}
+	if o.DataLenIs(4) {
+		t.Fatal("unexpected Option data length of 4")
+	}
+	if !(&Option{}).DataLenIs(0) {
+		t.Fatal("expected empty Option data length of 0")
+	}
+}
+
+func TestOptionsSort(t *testing.T) {
+	var (
+		a1 = &Option{OptionClass: 0x0001, Type: 0x01}
+		a2 = &Option{OptionClass: 0x0001, Type: 0x02}
+		b1 = &Option{OptionClass: 0x0002, Type: 0x01, Data: []byte{0, 0, 0, 1}}
+		b2 = &Option{OptionClass: 0x0002, Type: 0x01, Data: []byte{0, 0, 0, 0}}
+	)
+
+	o := Options{b1, a2, b2, a1}
+	o.Sort()
+
+	if !o.IsSorted() {
+		t.Fatal("Options not sorted")
+	}
+
+	// Options with equal class and type retain their relative order
+	if want, got := (Options{a1, a2, b1, b2}), o; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestOptionDataEqualConstantTime(t *testing.T) {
+	o := &Option{Data: []byte{0, 1, 2, 3}}
+
+	tests := []struct {
+		desc string
+		b    []byte
+		ok   bool
+	}{
+		{
+			desc: "equal",
+			b:    []byte{0, 1, 2, 3},
+			ok:   true,
+		},
+		{
+			desc: "different contents",
+			b:    []byte{0, 1, 2, 4},
+		},
+		{
+			desc: "different length",
+			b:    []byte{0, 1, 2, 3, 4, 5, 6, 7},
+		},
+		{
+			desc: "empty",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.ok, o.DataEqualConstantTime(tt.b); want != got {
+			t.Fatalf("unexpected equality:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestOptionsFromTLV(t *testing.T) {
+	opts, err := OptionsFromTLV([]TLV{
+		{Class: 0x0001, Type: 0x02, Critical: true, Data: []byte{1, 2, 3}},
+		{Class: 0x0002, Type: 0x03},
+		{Class: 0x0003, Type: 0x04, Data: []byte{1, 2, 3, 4, 5}},
+	})
+	if err != nil {
+		t.Fatalf("failed to construct Options: %v", err)
+	}
+
+	want := Options{
+		{OptionClass: 0x0001, Type: 0x02, FlagCritical: true, Data: []byte{1, 2, 3, 0}},
+		{OptionClass: 0x0002, Type: 0x03, Data: []byte{}},
+		{OptionClass: 0x0003, Type: 0x04, Data: []byte{1, 2, 3, 4, 5, 0, 0, 0}},
+	}
+	if got := opts; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	_, err = OptionsFromTLV([]TLV{
+		{Type: maxOptionType + 1},
+		{},
+		{Data: make([]byte, (maxOptionLength*4)+1)},
+	})
+	if err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+
+	for _, want := range []error{errInvalidOptionType, errInvalidOptionLength} {
+		if !errors.Is(err, want) {
+			t.Fatalf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestOptionsFitHeader(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts []*Option
+		err  error
+	}{
+		{
+			desc: "no Options",
+		},
+		{
+			desc: "maximum length",
+			opts: []*Option{
+				{Data: make([]byte, maxOptionLength*4)},
+				{Data: make([]byte, (maxOptionLength-1)*4)},
+			},
+		},
+		{
+			desc: "too long",
+			opts: []*Option{
+				{Data: make([]byte, maxOptionLength*4)},
+				{Data: make([]byte, maxOptionLength*4)},
+			},
+			err: errOptionsTooLong,
+		},
+		{
+			desc: "unaligned",
+			opts: []*Option{{Data: make([]byte, 3)}},
+			err:  errOptionsUnaligned,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.err, OptionsFitHeader(tt.opts); want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestOptionsFromMap(t *testing.T) {
+	m := map[OptionKey][]byte{
+		{Class: 0x0002, Type: 0x01}: {1},
+		{Class: 0x0001, Type: 0x02}: {2, 2, 2, 2},
+		{Class: 0x0001, Type: 0x01}: nil,
+	}
+
+	want := Options{
+		{OptionClass: 0x0001, Type: 0x01, Data: []byte{}},
+		{OptionClass: 0x0001, Type: 0x02, Data: []byte{2, 2, 2, 2}},
+		{OptionClass: 0x0002, Type: 0x01, Data: []byte{1, 0, 0, 0}},
+	}
+
+	// Repeat to exercise random map iteration order
+	for i := 0; i < 10; i++ {
+		opts, err := OptionsFromMap(m)
+		if err != nil {
+			t.Fatalf("failed to construct Options: %v", err)
+		}
+
+		if got := opts; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+
+	_, err := OptionsFromMap(map[OptionKey][]byte{
+		{Type: maxOptionType + 1}: nil,
+	})
+	if !errors.Is(err, errInvalidOptionType) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestUnmarshalOptionsN(t *testing.T) {
+	b := []byte{
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+		// Payload
+		0xff, 0xff, 0xff, 0xff,
+	}
+
+	tests := []struct {
+		desc string
+		b    []byte
+		n    int
+		o    Options
+		err  error
+	}{
+		{
+			desc: "negative length",
+			b:    b,
+			n:    -4,
+			err:  errOptionsTooLong,
+		},
+		{
+			desc: "length too long",
+			b:    make([]byte, MaxHeaderOptionsLen+4),
+			n:    MaxHeaderOptionsLen + 4,
+			err:  errOptionsTooLong,
+		},
+		{
+			desc: "input too short",
+			b:    b[:4],
+			n:    8,
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "unaligned length",
+			b:    b,
+			n:    6,
+			err:  errOptionsUnaligned,
+		},
+		{
+			desc: "options do not consume length",
+			b:    b,
+			n:    4,
+			err:  errOptionsMisaligned,
+		},
+		{
+			desc: "zero length OK",
+			b:    b,
+		},
+		{
+			desc: "trailing payload OK",
+			b:    b,
+			n:    8,
+			o: Options{{
+				OptionClass: 0x0001,
+				Type:        0x02,
+				Data:        []byte{0, 1, 2, 3},
+				DataLen:     4,
+			}},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		o, err := UnmarshalOptionsN(tt.b, tt.n)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.o, o; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Options:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestOptionSetPaddedData(t *testing.T) {
+	o := &Option{OptionClass: 0x0001}
+	if err := o.SetPaddedData([]byte{1, 2, 3}, 3); err != errInvalidOptionDataLength {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidOptionDataLength, err)
+	}
+	if err := o.SetPaddedData([]byte{1, 2, 3, 0}, 5); err != errInvalidOptionLength {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidOptionLength, err)
+	}
+	if o.Data != nil || o.DataLen != 0 {
+		t.Fatalf("Option modified after error: %v", o)
+	}
+
+	if err := o.SetPaddedData([]byte{1, 2, 3, 0}, 3); err != nil {
+		t.Fatalf("failed to set padded data: %v", err)
+	}
+
+	if want, got := []byte{1, 2, 3}, o.SignificantData(); !bytes.Equal(want, got) {
+		t.Fatalf("unexpected significant data:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Padding is transmitted, but DataLen is not, so a decoded Option
+	// treats all of its Data as significant
+	b, err := o.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Option: %v", err)
+	}
+
+	do := new(Option)
+	if err := do.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Option: %v", err)
+	}
+
+	if want, got := 4, do.DataLen; want != got {
+		t.Fatalf("unexpected decoded DataLen:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := []byte{1, 2, 3, 0}, do.SignificantData(); !bytes.Equal(want, got) {
+		t.Fatalf("unexpected decoded significant data:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestOptionSetPaddedDataZero(t *testing.T) {
+	o := &Option{OptionClass: 0x0001}
+	if err := o.SetPaddedData([]byte{0, 0, 0, 0}, 0); err != nil {
+		t.Fatalf("failed to set padded data: %v", err)
+	}
+
+	if want, got := 0, len(o.SignificantData()); want != got {
+		t.Fatalf("unexpected significant data length:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	b, err := o.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Option: %v", err)
+	}
+
+	do := new(Option)
+	if err := do.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal Option: %v", err)
+	}
+
+	if want, got := []byte{0, 0, 0, 0}, do.Data; !bytes.Equal(want, got) {
+		t.Fatalf("unexpected decoded data:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,266 @@
+package geneve
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+var (
+	// errPayloadTooShort indicates that a payload is shorter than required.
+	errPayloadTooShort = errors.New("payload too short")
+
+	// errInvalidPayloadLength indicates that a payload length is negative.
+	errInvalidPayloadLength = errors.New("invalid payload length")
+
+	// errTooManyPackets indicates that a byte slice contains more packets
+	// than permitted.
+	errTooManyPackets = errors.New("too many packets")
+
+	// errExceedsMTU indicates that a datagram is larger than an MTU.
+	errExceedsMTU = errors.New("datagram exceeds MTU")
+)
+
+// A Packet is a Geneve Header and the payload it encapsulates.
+type Packet struct {
+	// Header is the Geneve header of the Packet.
+	Header *Header
+
+	// Payload is the protocol data unit trailing the Header, whose type is
+	// specified by the Header's ProtocolType.
+	Payload []byte
+}
+
+// ControlMessage returns the Payload of a Packet and true if its Header has
+// FlagOAM set, indicating that the Payload is a control message rather than
+// data.  Otherwise, it returns nil and false.
+func (p *Packet) ControlMessage() ([]byte, bool) {
+	if p.Header == nil || !p.Header.FlagOAM {
+		return nil, false
+	}
+
+	return p.Payload, true
+}
+
+// PayloadMatchesProtocol reports whether the Payload of a Packet plausibly
+// matches the ProtocolType of its Header, which is useful for detecting
+// packets which were encapsulated incorrectly.  The check is a best-effort
+// heuristic:
+//   - IPv4 and IPv6 payloads must begin with the matching IP version
+//   - Ethernet payloads must contain at least an Ethernet frame header
+//   - ARP and MPLS payloads must contain at least a fixed ARP header or an
+//     MPLS label stack entry
+//
+// PayloadMatchesProtocol returns true for any other ProtocolType, or if the
+// Packet has no Header, because no check applies.
+func (p *Packet) PayloadMatchesProtocol() bool {
+	if p.Header == nil {
+		return true
+	}
+
+	switch p.Header.ProtocolType {
+	case ProtocolTypeIPv4:
+		return len(p.Payload) > 0 && p.Payload[0]>>4 == 4
+	case ProtocolTypeIPv6:
+		return len(p.Payload) > 0 && p.Payload[0]>>4 == 6
+	case ProtocolTypeEthernet:
+		return len(p.Payload) >= ethernetHeaderLen
+	case ProtocolTypeARP:
+		return len(p.Payload) >= 8
+	case ProtocolTypeMPLS:
+		return len(p.Payload) >= 4
+	default:
+		return true
+	}
+}
+
+// Decapsulate unmarshals a Header from a byte slice, and returns the Header
+// and the payload trailing it.  The payload points into b, and is not copied.
+func Decapsulate(b []byte) (*Header, []byte, error) {
+	h := new(Header)
+	off, err := h.unmarshalBinaryOffset(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return h, b[off:], nil
+}
+
+// DecapsulateMin is like Decapsulate, but also returns an error if the
+// payload trailing the Header is shorter than minPayload bytes, such as
+// when an inner Ethernet frame header is required.
+func DecapsulateMin(b []byte, minPayload int) (*Header, []byte, error) {
+	h, payload, err := Decapsulate(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(payload) < minPayload {
+		return nil, nil, errPayloadTooShort
+	}
+
+	return h, payload, nil
+}
+
+// ParsePackets parses a byte slice containing one or more consecutive Geneve
+// packets, such as a coalesced UDP payload produced by generic receive offload.
+// Each Packet's Payload points into b, and is not copied.
+//
+// Geneve headers do not carry the length of their payload, and neither do
+// raw Ethernet frames, so the boundary between packets cannot be determined
+// from b alone.  payloadLen is invoked with each parsed Header, and must
+// return the length of the payload trailing it, such as a segment size known
+// by the caller.
+func ParsePackets(b []byte, payloadLen func(h *Header) int) ([]*Packet, error) {
+	ps, err := parsePackets(b, -1, payloadLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// ParsePacketsN is like ParsePackets, but returns an error if b contains more
+// than max packets, so that a crafted input from an untrusted source cannot
+// force excessive work or allocation.  A negative max is treated as zero, so
+// that the bound can never be removed.
+//
+// Unlike ParsePackets, if an error occurs, ParsePacketsN returns the Packets
+// parsed before the error along with the error, so the caller may choose to
+// process them.
+func ParsePacketsN(b []byte, max int, payloadLen func(h *Header) int) ([]*Packet, error) {
+	// parsePackets treats a negative max as unlimited
+	if max < 0 {
+		max = 0
+	}
+
+	return parsePackets(b, max, payloadLen)
+}
+
+// parsePackets implements ParsePackets and ParsePacketsN.  If max is
+// negative, the number of Packets is not limited.  The Packets parsed before
+// any error are returned along with the error.
+func parsePackets(b []byte, max int, payloadLen func(h *Header) int) ([]*Packet, error) {
+	var ps []*Packet
+	for len(b) > 0 {
+		if len(ps) == max {
+			return ps, errTooManyPackets
+		}
+
+		h, payload, err := Decapsulate(b)
+		if err != nil {
+			return ps, err
+		}
+
+		n := payloadLen(h)
+		if n < 0 {
+			return ps, errInvalidPayloadLength
+		}
+		if n > len(payload) {
+			return ps, io.ErrUnexpectedEOF
+		}
+
+		ps = append(ps, &Packet{
+			Header:  h,
+			Payload: payload[:n:n],
+		})
+
+		b = payload[n:]
+	}
+
+	return ps, nil
+}
+
+// ValidateDatagram verifies that b contains a well-formed Geneve datagram
+// of the current Version, and returns the offset of the payload trailing its
+// header.  The same bounds checks are performed as by Header.UnmarshalBinary,
+// but no Header or Options are allocated.
+func ValidateDatagram(b []byte) (int, error) {
+	var h Header
+	ol, err := h.unmarshalFixed(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if h.Version != Version {
+		return 0, errInvalidVersion
+	}
+
+	if _, err := checkOptions(b[headerLen : headerLen+ol]); err != nil {
+		return 0, err
+	}
+
+	return headerLen + ol, nil
+}
+
+// CheckMTU verifies that a Geneve datagram in b, consisting of a header and
+// its payload, is no longer than mtu bytes.  This is useful for cheaply
+// rejecting oversized datagrams before they are decoded.  Only the bytes of
+// the Geneve datagram are counted, so mtu must not include the overhead of
+// any outer UDP or IP headers.
+func CheckMTU(b []byte, mtu int) error {
+	if len(b) > mtu {
+		return errExceedsMTU
+	}
+
+	return nil
+}
+
+// EncapsulateIPv4 creates a Packet which encapsulates an IPv4 packet, using
+// a Header with the specified VNI and Options and ProtocolTypeIPv4.  The
+// Header is verified as with NewHeaderWithOptions.  The payload is not copied.
+func EncapsulateIPv4(vni VNI, ipPayload []byte, opts ...*Option) (*Packet, error) {
+	return encapsulate(vni, ProtocolTypeIPv4, ipPayload, opts)
+}
+
+// EncapsulateIPv6 is like EncapsulateIPv4, but encapsulates an IPv6 packet
+// using ProtocolTypeIPv6.
+func EncapsulateIPv6(vni VNI, ipPayload []byte, opts ...*Option) (*Packet, error) {
+	return encapsulate(vni, ProtocolTypeIPv6, ipPayload, opts)
+}
+
+// encapsulate creates a Packet with a verified Header.
+func encapsulate(vni VNI, proto ProtocolType, payload []byte, opts []*Option) (*Packet, error) {
+	h, err := NewHeaderWithOptions(vni, proto, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Packet{
+		Header:  h,
+		Payload: payload,
+	}, nil
+}
+
+// DatagramLen returns the total length of a Geneve datagram whose header is
+// in b, and which carries a payload of payloadLen bytes.  Only the fixed
+// header in b is inspected to determine the length of the options area.
+func DatagramLen(b []byte, payloadLen int) (int, error) {
+	if payloadLen < 0 {
+		return 0, errInvalidPayloadLength
+	}
+
+	ol, err := DeclaredOptionsLen(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return headerLen + ol + payloadLen, nil
+}
+
+// MarshalVectored marshals a Header into binary form, and appends it and
+// payload to dst as separate buffers, for use with vectored I/O such as
+// net.Buffers.WriteTo.  The payload is not copied.  A single net.Buffers may
+// be reused for many datagrams by truncating it to length zero.
+//
+// If the Header cannot be marshaled, an error is returned and dst is not
+// modified.
+func (h *Header) MarshalVectored(payload []byte, dst *net.Buffers) error {
+	b, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	*dst = append(*dst, b, payload)
+	return nil
+}
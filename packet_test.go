@@ -0,0 +1,696 @@
+package geneve
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDecapsulateMin(t *testing.T) {
+	b := []byte{
+		// Header
+		0x01,
+		0x00,
+		0x65, 0x58,
+		0x03, 0x02, 0x01,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x00,
+		// Payload
+		1, 2, 3, 4,
+	}
+
+	tests := []struct {
+		desc    string
+		b       []byte
+		min     int
+		h       *Header
+		payload []byte
+		err     error
+	}{
+		{
+			desc: "input bytes too short for header",
+			b:    b[:headerLen-1],
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "payload too short",
+			b:    b,
+			min:  5,
+			err:  errPayloadTooShort,
+		},
+		{
+			desc: "empty payload OK",
+			b:    b[:12],
+			h: &Header{
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          0x00030201,
+				Options: []*Option{{
+					OptionClass: 0x0001,
+					Type:        0x02,
+					Data:        []byte{},
+				}},
+			},
+			payload: []byte{},
+		},
+		{
+			desc: "OK",
+			b:    b,
+			min:  4,
+			h: &Header{
+				ProtocolType: ProtocolTypeEthernet,
+				VNI:          0x00030201,
+				Options: []*Option{{
+					OptionClass: 0x0001,
+					Type:        0x02,
+					Data:        []byte{},
+				}},
+			},
+			payload: []byte{1, 2, 3, 4},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		h, payload, err := DecapsulateMin(tt.b, tt.min)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.h, h; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Header:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		if want, got := tt.payload, payload; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected payload:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestParsePackets(t *testing.T) {
+	b := []byte{
+		// Header
+		0x00,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x01,
+		0x00,
+		// Payload
+		1, 2, 3,
+		// Header
+		0x01,
+		0x00,
+		0x65, 0x58,
+		0x00, 0x00, 0x02,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x00,
+		// Payload
+		4, 5, 6,
+	}
+
+	tests := []struct {
+		desc string
+		b    []byte
+		n    int
+		ps   []*Packet
+		err  error
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc: "negative payload length",
+			b:    b,
+			n:    -1,
+			err:  errInvalidPayloadLength,
+		},
+		{
+			desc: "payload length too long",
+			b:    b,
+			n:    4,
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "truncated second header",
+			b:    b[:13],
+			n:    3,
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "OK",
+			b:    b,
+			n:    3,
+			ps: []*Packet{
+				{
+					Header: &Header{
+						ProtocolType: ProtocolTypeEthernet,
+						VNI:          1,
+					},
+					Payload: []byte{1, 2, 3},
+				},
+				{
+					Header: &Header{
+						ProtocolType: ProtocolTypeEthernet,
+						VNI:          2,
+						Options: []*Option{{
+							OptionClass: 0x0001,
+							Type:        0x02,
+							Data:        []byte{},
+						}},
+					},
+					Payload: []byte{4, 5, 6},
+				},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		ps, err := ParsePackets(tt.b, func(_ *Header) int {
+			return tt.n
+		})
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.ps, ps; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Packets:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestValidateDatagram(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		off  int
+		err  error
+	}{
+		{
+			desc: "input bytes too short for header",
+			b:    make([]byte, headerLen-1),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "invalid version",
+			b: []byte{
+				0x40,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+			},
+			err: errInvalidVersion,
+		},
+		{
+			desc: "options misaligned",
+			b: []byte{
+				// Header
+				0x01,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x01,
+				// Payload
+				1, 2, 3, 4,
+			},
+			err: errOptionsMisaligned,
+		},
+		{
+			desc: "OK",
+			b: []byte{
+				// Header
+				0x01,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+				// Option
+				0x00, 0x01,
+				0x02,
+				0x00,
+				// Payload
+				1, 2, 3,
+			},
+			off: 12,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		off, err := ValidateDatagram(tt.b)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.off, off; want != got {
+			t.Fatalf("unexpected offset:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestValidateDatagramAllocations(t *testing.T) {
+	b := []byte{
+		// Header
+		0x02,
+		0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00,
+		0x00,
+		// Option
+		0x00, 0x01,
+		0x02,
+		0x01,
+		0, 1, 2, 3,
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := ValidateDatagram(b); err != nil {
+			t.Fatalf("failed to validate datagram: %v", err)
+		}
+	})
+
+	if allocs != 0 {
+		t.Fatalf("unexpected allocations: %v", allocs)
+	}
+}
+
+func TestEncapsulateIP(t *testing.T) {
+	payload := []byte{0x45, 0x00}
+	opt := &Option{OptionClass: 0x0001}
+
+	if _, err := EncapsulateIPv4(MaxVNI+1, payload); err != errInvalidVNI {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidVNI, err)
+	}
+
+	tests := []struct {
+		desc  string
+		fn    func(vni VNI, ipPayload []byte, opts ...*Option) (*Packet, error)
+		proto ProtocolType
+	}{
+		{
+			desc:  "IPv4",
+			fn:    EncapsulateIPv4,
+			proto: ProtocolTypeIPv4,
+		},
+		{
+			desc:  "IPv6",
+			fn:    EncapsulateIPv6,
+			proto: ProtocolTypeIPv6,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		p, err := tt.fn(1, payload, opt)
+		if err != nil {
+			t.Fatalf("failed to encapsulate: %v", err)
+		}
+
+		want := &Packet{
+			Header: &Header{
+				ProtocolType: tt.proto,
+				VNI:          1,
+				Options:      []*Option{opt},
+			},
+			Payload: payload,
+		}
+
+		if got := p; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected Packet:\n- want: %v\n-  got: %v", want, got)
+		}
+		if p.Header.FlagCritical {
+			t.Fatal("Header marked critical without a critical Option")
+		}
+
+		// A critical Option must mark the encapsulating Header critical
+		cp, err := tt.fn(1, payload, opt, &Option{OptionClass: 0x0002, FlagCritical: true})
+		if err != nil {
+			t.Fatalf("failed to encapsulate with critical Option: %v", err)
+		}
+		if !cp.Header.FlagCritical {
+			t.Fatal("Header not marked critical with a critical Option")
+		}
+	}
+}
+
+func TestDatagramLen(t *testing.T) {
+	tests := []struct {
+		desc string
+		b    []byte
+		plen int
+		n    int
+		err  error
+	}{
+		{
+			desc: "input bytes too short for header",
+			b:    make([]byte, headerLen-1),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			desc: "negative payload length",
+			b:    make([]byte, headerLen),
+			plen: -1,
+			err:  errInvalidPayloadLength,
+		},
+		{
+			desc: "no options",
+			b:    make([]byte, headerLen),
+			plen: 14,
+			n:    22,
+		},
+		{
+			desc: "options",
+			b: []byte{
+				0x05,
+				0x00,
+				0x00, 0x00,
+				0x00, 0x00, 0x00,
+				0x00,
+			},
+			plen: 14,
+			n:    42,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		n, err := DatagramLen(tt.b, tt.plen)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want, got := tt.n, n; want != got {
+			t.Fatalf("unexpected length:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestPacketControlMessage(t *testing.T) {
+	payload := []byte{0xff, 0xff, 0xff, 0xff}
+
+	tests := []struct {
+		desc string
+		p    *Packet
+		ok   bool
+	}{
+		{
+			desc: "no header",
+			p:    &Packet{Payload: payload},
+		},
+		{
+			desc: "data",
+			p: &Packet{
+				Header:  &Header{},
+				Payload: payload,
+			},
+		},
+		{
+			desc: "OAM",
+			p: &Packet{
+				Header:  &Header{FlagOAM: true},
+				Payload: payload,
+			},
+			ok: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		msg, ok := tt.p.ControlMessage()
+		if want, got := tt.ok, ok; want != got {
+			t.Fatalf("unexpected ControlMessage result:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var want []byte
+		if tt.ok {
+			want = payload
+		}
+		if got := msg; !bytes.Equal(want, got) {
+			t.Fatalf("unexpected control message:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestHeaderMarshalVectored(t *testing.T) {
+	var bufs net.Buffers
+	if err := (&Header{VNI: MaxVNI + 1}).MarshalVectored(nil, &bufs); err != errInvalidVNI {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errInvalidVNI, err)
+	}
+	if len(bufs) != 0 {
+		t.Fatalf("buffers modified after error: %v", bufs)
+	}
+
+	h := &Header{
+		ProtocolType: ProtocolTypeIPv4,
+		VNI:          10,
+		Options: []*Option{{
+			OptionClass: 0x0001,
+			Data:        []byte{0, 1, 2, 3},
+		}},
+	}
+
+	payload := []byte{0xff, 0xff, 0xff, 0xff}
+	for i := 0; i < 2; i++ {
+		if err := h.MarshalVectored(payload, &bufs); err != nil {
+			t.Fatalf("failed to marshal vectored: %v", err)
+		}
+	}
+
+	if want, got := 4, len(bufs); want != got {
+		t.Fatalf("unexpected number of buffers:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The payload must not be copied
+	if &bufs[1][0] != &payload[0] {
+		t.Fatal("payload was copied")
+	}
+
+	hb, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal Header: %v", err)
+	}
+
+	var got bytes.Buffer
+	if _, err := bufs.WriteTo(&got); err != nil {
+		t.Fatalf("failed to write buffers: %v", err)
+	}
+
+	want := append(append(append(hb, payload...), hb...), payload...)
+	if !bytes.Equal(want, got.Bytes()) {
+		t.Fatalf("unexpected datagrams:\n- want: %v\n-  got: %v", want, got.Bytes())
+	}
+}
+
+func TestCheckMTU(t *testing.T) {
+	b := make([]byte, 1500)
+
+	tests := []struct {
+		desc string
+		mtu  int
+		err  error
+	}{
+		{
+			desc: "larger",
+			mtu:  9000,
+		},
+		{
+			desc: "equal",
+			mtu:  1500,
+		},
+		{
+			desc: "smaller",
+			mtu:  1499,
+			err:  errExceedsMTU,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if want, got := tt.err, CheckMTU(b, tt.mtu); want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestPacketPayloadMatchesProtocol(t *testing.T) {
+	tests := []struct {
+		desc    string
+		proto   ProtocolType
+		payload []byte
+		ok      bool
+	}{
+		{
+			desc:    "IPv4 OK",
+			proto:   ProtocolTypeIPv4,
+			payload: []byte{0x45},
+			ok:      true,
+		},
+		{
+			desc:    "IPv4 with IPv6 payload",
+			proto:   ProtocolTypeIPv4,
+			payload: []byte{0x60},
+		},
+		{
+			desc:  "IPv4 empty",
+			proto: ProtocolTypeIPv4,
+		},
+		{
+			desc:    "IPv6 OK",
+			proto:   ProtocolTypeIPv6,
+			payload: []byte{0x60},
+			ok:      true,
+		},
+		{
+			desc:    "IPv6 with IPv4 payload",
+			proto:   ProtocolTypeIPv6,
+			payload: []byte{0x45},
+		},
+		{
+			desc:    "Ethernet OK",
+			proto:   ProtocolTypeEthernet,
+			payload: make([]byte, ethernetHeaderLen),
+			ok:      true,
+		},
+		{
+			desc:    "Ethernet too short",
+			proto:   ProtocolTypeEthernet,
+			payload: make([]byte, ethernetHeaderLen-1),
+		},
+		{
+			desc:    "ARP too short",
+			proto:   ProtocolTypeARP,
+			payload: make([]byte, 7),
+		},
+		{
+			desc:    "MPLS OK",
+			proto:   ProtocolTypeMPLS,
+			payload: make([]byte, 4),
+			ok:      true,
+		},
+		{
+			desc:  "unknown",
+			proto: 0xffff,
+			ok:    true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		p := &Packet{
+			Header:  &Header{ProtocolType: tt.proto},
+			Payload: tt.payload,
+		}
+
+		if want, got := tt.ok, p.PayloadMatchesProtocol(); want != got {
+			t.Fatalf("unexpected PayloadMatchesProtocol:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestParsePacketsN(t *testing.T) {
+	// Three minimal packets, each with a single byte payload
+	var b []byte
+	for i := 0; i < 3; i++ {
+		b = append(b, 0x00, 0x00, 0x65, 0x58, 0x00, 0x00, byte(i), 0x00, byte(i))
+	}
+
+	payloadLen := func(_ *Header) int { return 1 }
+
+	tests := []struct {
+		desc string
+		b    []byte
+		max  int
+		n    int
+		err  error
+	}{
+		{
+			desc: "below maximum",
+			b:    b,
+			max:  4,
+			n:    3,
+		},
+		{
+			desc: "at maximum",
+			b:    b,
+			max:  3,
+			n:    3,
+		},
+		{
+			desc: "above maximum",
+			b:    b,
+			max:  2,
+			n:    2,
+			err:  errTooManyPackets,
+		},
+		{
+			desc: "zero maximum",
+			b:    b,
+			err:  errTooManyPackets,
+		},
+		{
+			desc: "negative maximum",
+			b:    b,
+			max:  -1,
+			err:  errTooManyPackets,
+		},
+		{
+			desc: "truncated third packet",
+			b:    b[:len(b)-1],
+			max:  3,
+			n:    2,
+			err:  io.ErrUnexpectedEOF,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		ps, err := ParsePacketsN(tt.b, tt.max, payloadLen)
+		if want, got := tt.err, err; want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		// Packets parsed before an error are returned
+		if want, got := tt.n, len(ps); want != got {
+			t.Fatalf("unexpected number of packets:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		for j, p := range ps {
+			if want, got := VNI(j), p.Header.VNI; want != got {
+				t.Fatalf("unexpected VNI for packet %d:\n- want: %v\n-  got: %v", j, want, got)
+			}
+		}
+	}
+}